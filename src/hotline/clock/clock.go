@@ -0,0 +1,142 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells current time and creates tickers, so time driven code runs
+// on system time in production and is driven manually in tests and replays.
+type Clock interface {
+	Now() time.Time
+	NewTicker(period time.Duration) Ticker
+}
+
+// Ticker delivers ticks of its period on channel, dropping ticks for slow
+// receivers like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is Clock of the time package.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) NewTicker(period time.Duration) Ticker {
+	return &systemTicker{ticker: time.NewTicker(period)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// ManualClock stands still until moved by Advance or Set, firing tickers
+// whose ticks were passed. Safe for concurrent use.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker panics for non-positive period, as time.NewTicker does.
+func (c *ManualClock) NewTicker(period time.Duration) Ticker {
+	if period <= 0 {
+		panic("clock: non-positive period for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ticker := &manualTicker{
+		clock:  c,
+		period: period,
+		next:   c.now.Add(period),
+		ch:     make(chan time.Time, 1),
+	}
+	c.tickers = append(c.tickers, ticker)
+	return ticker
+}
+
+// Advance moves clock by duration, panics for negative duration as clock
+// never moves backwards.
+func (c *ManualClock) Advance(duration time.Duration) {
+	if duration < 0 {
+		panic("clock: negative duration for Advance")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setNow(c.now.Add(duration))
+}
+
+// Set moves clock to now, clock never moves backwards.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.now) {
+		c.setNow(now)
+	}
+}
+
+func (c *ManualClock) setNow(now time.Time) {
+	c.now = now
+	for _, ticker := range c.tickers {
+		ticker.fire(now)
+	}
+}
+
+func (c *ManualClock) stop(stopped *manualTicker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, ticker := range c.tickers {
+		if ticker == stopped {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	clock  *ManualClock
+	period time.Duration
+	next   time.Time
+	ch     chan time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.stop(t)
+}
+
+func (t *manualTicker) fire(now time.Time) {
+	for !t.next.After(now) {
+		select {
+		case t.ch <- t.next:
+		default:
+		}
+		t.next = t.next.Add(t.period)
+	}
+}
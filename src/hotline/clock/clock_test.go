@@ -0,0 +1,107 @@
+package clock_test
+
+import (
+	"hotline/clock"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manual Clock", func() {
+	start := clock.ParseTime("2025-02-22T12:04:05Z")
+
+	It("stands still until advanced", func() {
+		manual := clock.NewManualClock(start)
+		Expect(manual.Now()).To(Equal(start))
+
+		manual.Advance(10 * time.Second)
+		Expect(manual.Now()).To(Equal(clock.ParseTime("2025-02-22T12:04:15Z")))
+	})
+
+	It("never moves backwards", func() {
+		manual := clock.NewManualClock(start)
+		manual.Set(clock.ParseTime("2025-02-22T12:00:00Z"))
+		Expect(manual.Now()).To(Equal(start))
+
+		manual.Set(clock.ParseTime("2025-02-22T12:05:00Z"))
+		Expect(manual.Now()).To(Equal(clock.ParseTime("2025-02-22T12:05:00Z")))
+	})
+
+	It("ticks once period passes", func() {
+		manual := clock.NewManualClock(start)
+		ticker := manual.NewTicker(10 * time.Second)
+
+		manual.Advance(9 * time.Second)
+		Expect(ticker.C()).NotTo(Receive())
+
+		manual.Advance(time.Second)
+		Expect(ticker.C()).To(Receive(Equal(clock.ParseTime("2025-02-22T12:04:15Z"))))
+	})
+
+	It("drops ticks not received in time", func() {
+		manual := clock.NewManualClock(start)
+		ticker := manual.NewTicker(10 * time.Second)
+
+		manual.Advance(35 * time.Second)
+		Expect(ticker.C()).To(Receive(Equal(clock.ParseTime("2025-02-22T12:04:15Z"))))
+		Expect(ticker.C()).NotTo(Receive())
+
+		manual.Advance(5 * time.Second)
+		Expect(ticker.C()).To(Receive(Equal(clock.ParseTime("2025-02-22T12:04:45Z"))))
+	})
+
+	It("refuses to move backwards by negative duration", func() {
+		manual := clock.NewManualClock(start)
+
+		Expect(func() { manual.Advance(-time.Second) }).To(Panic())
+		Expect(manual.Now()).To(Equal(start))
+	})
+
+	It("refuses ticker without positive period", func() {
+		manual := clock.NewManualClock(start)
+
+		Expect(func() { manual.NewTicker(0) }).To(Panic())
+		Expect(func() { manual.NewTicker(-time.Second) }).To(Panic())
+	})
+
+	It("stopped ticker does not tick", func() {
+		manual := clock.NewManualClock(start)
+		ticker := manual.NewTicker(10 * time.Second)
+		ticker.Stop()
+
+		manual.Advance(time.Minute)
+		Expect(ticker.C()).NotTo(Receive())
+	})
+})
+
+var _ = Describe("System Clock", func() {
+	It("tells current time", func() {
+		before := time.Now()
+		now := clock.SystemClock{}.Now()
+
+		Expect(now).NotTo(BeTemporally("<", before))
+		Expect(now).NotTo(BeTemporally(">", time.Now()))
+	})
+
+	It("ticks until stopped", func() {
+		ticker := clock.SystemClock{}.NewTicker(time.Millisecond)
+
+		Eventually(ticker.C()).Should(Receive())
+		ticker.Stop()
+	})
+
+	It("refuses ticker without positive period", func() {
+		Expect(func() { clock.SystemClock{}.NewTicker(0) }).To(Panic())
+	})
+})
+
+var _ = Describe("ParseTime", func() {
+	It("parses RFC 3339 time", func() {
+		Expect(clock.ParseTime("2025-02-22T12:04:05Z")).To(Equal(time.Date(2025, 2, 22, 12, 4, 5, 0, time.UTC)))
+	})
+
+	It("returns zero time for malformed value", func() {
+		Expect(clock.ParseTime("yesterday")).To(BeZero())
+	})
+})
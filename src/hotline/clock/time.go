@@ -0,0 +1,12 @@
+package clock
+
+import "time"
+
+// ParseTime parses RFC 3339 time, returns zero time for malformed value.
+func ParseTime(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+var ErrInvalidLayout = errors.New("invalid bucket layout")
+
+// BucketLayout maps latencies to bucket indexes and indexes back to bucket
+// bounds. Bucket includes its lower bound and excludes the upper one.
+type BucketLayout interface {
+	key(latency float64) bucketIndex
+	bucketFrom(index bucketIndex) float64
+	bucketTo(index bucketIndex) float64
+	equal(other BucketLayout) bool
+}
+
+const (
+	defaultGrowthFactor        = 1.15
+	defaultZeroBucketThreshold = 1.0
+
+	minScale = -10
+	maxScale = 20
+)
+
+// exponentialBucketLayout grows buckets by growth factor, so every bucket
+// has the same relative error. Latencies below zero bucket threshold share
+// a single bucket.
+type exponentialBucketLayout struct {
+	growthFactor        float64
+	growthDivisor       float64
+	zeroBucketThreshold float64
+	zeroBucketIndex     bucketIndex
+	scale               *int32
+}
+
+type ExponentialLayoutOption func(*exponentialBucketLayout)
+
+// WithGrowthFactor sets ratio of upper and lower bound of every bucket.
+// Defaults to 1.15.
+func WithGrowthFactor(growthFactor float64) ExponentialLayoutOption {
+	return func(l *exponentialBucketLayout) {
+		l.growthFactor = growthFactor
+	}
+}
+
+// WithRelativeError sets growth factor, so the middle of the bucket is at
+// most relativeError away from any latency in it.
+func WithRelativeError(relativeError float64) ExponentialLayoutOption {
+	return func(l *exponentialBucketLayout) {
+		l.growthFactor = (1 + relativeError) / (1 - relativeError)
+	}
+}
+
+// WithScale sets growth factor to 2^(2^-scale), so bucket indexes match
+// OTLP exponential histogram of the same scale. Scale must be in [-10, 20].
+func WithScale(scale int32) ExponentialLayoutOption {
+	return func(l *exponentialBucketLayout) {
+		l.scale = &scale
+		l.growthFactor = math.Exp2(math.Exp2(-float64(scale)))
+	}
+}
+
+// WithZeroThreshold sets the latency below which all latencies fall into
+// the zero bucket. Defaults to 1.0, fitting milliseconds. Use smaller
+// threshold for latencies in seconds.
+func WithZeroThreshold(threshold float64) ExponentialLayoutOption {
+	return func(l *exponentialBucketLayout) {
+		l.zeroBucketThreshold = threshold
+	}
+}
+
+func NewExponentialLayout(opts ...ExponentialLayoutOption) (BucketLayout, error) {
+	layout := &exponentialBucketLayout{
+		growthFactor:        defaultGrowthFactor,
+		zeroBucketThreshold: defaultZeroBucketThreshold,
+	}
+	for _, opt := range opts {
+		opt(layout)
+	}
+	if layout.scale != nil && (*layout.scale < minScale || *layout.scale > maxScale) {
+		return nil, fmt.Errorf("%w: scale must be in [%d, %d], got %d", ErrInvalidLayout, minScale, maxScale, *layout.scale)
+	}
+	if !(layout.growthFactor > 1) || math.IsInf(layout.growthFactor, 1) {
+		return nil, fmt.Errorf("%w: growth factor must be greater than 1, got %v", ErrInvalidLayout, layout.growthFactor)
+	}
+	if !(layout.zeroBucketThreshold > 0) || math.IsInf(layout.zeroBucketThreshold, 1) {
+		return nil, fmt.Errorf("%w: zero threshold must be positive, got %v", ErrInvalidLayout, layout.zeroBucketThreshold)
+	}
+	layout.init()
+	return layout, nil
+}
+
+func newExponentialLayout() *exponentialBucketLayout {
+	layout := &exponentialBucketLayout{
+		growthFactor:        defaultGrowthFactor,
+		zeroBucketThreshold: defaultZeroBucketThreshold,
+	}
+	layout.init()
+	return layout
+}
+
+func (l *exponentialBucketLayout) init() {
+	l.growthDivisor = math.Log(l.growthFactor)
+	l.zeroBucketIndex = bucketIndex(math.Floor(math.Log(l.zeroBucketThreshold)/l.growthDivisor)) - 1
+}
+
+func (l *exponentialBucketLayout) key(latency float64) bucketIndex {
+	if latency < l.zeroBucketThreshold {
+		return l.zeroBucketIndex
+	}
+	index := bucketIndex(math.Floor(math.Log(latency) / l.growthDivisor))
+	return max(index, l.zeroBucketIndex+1)
+}
+
+func (l *exponentialBucketLayout) bucketFrom(index bucketIndex) float64 {
+	if index == l.zeroBucketIndex {
+		return 0
+	}
+	return math.Max(l.zeroBucketThreshold, math.Pow(l.growthFactor, float64(index)))
+}
+
+func (l *exponentialBucketLayout) bucketTo(index bucketIndex) float64 {
+	if index == l.zeroBucketIndex {
+		return l.zeroBucketThreshold
+	}
+	return math.Pow(l.growthFactor, float64(index+1))
+}
+
+func (l *exponentialBucketLayout) equal(other BucketLayout) bool {
+	otherLayout, ok := other.(*exponentialBucketLayout)
+	return ok &&
+		l.growthFactor == otherLayout.growthFactor &&
+		l.zeroBucketThreshold == otherLayout.zeroBucketThreshold
+}
+
+// linearBucketLayout splits latencies to buckets of the same width.
+type linearBucketLayout struct {
+	width float64
+}
+
+func NewLinearLayout(width float64) (BucketLayout, error) {
+	if !(width > 0) || math.IsInf(width, 1) {
+		return nil, fmt.Errorf("%w: bucket width must be positive, got %v", ErrInvalidLayout, width)
+	}
+	return &linearBucketLayout{width: width}, nil
+}
+
+func (l *linearBucketLayout) key(latency float64) bucketIndex {
+	if latency < 0 {
+		return 0
+	}
+	return bucketIndex(math.Floor(latency / l.width))
+}
+
+func (l *linearBucketLayout) bucketFrom(index bucketIndex) float64 {
+	return float64(index) * l.width
+}
+
+func (l *linearBucketLayout) bucketTo(index bucketIndex) float64 {
+	return float64(index+1) * l.width
+}
+
+func (l *linearBucketLayout) equal(other BucketLayout) bool {
+	otherLayout, ok := other.(*linearBucketLayout)
+	return ok && l.width == otherLayout.width
+}
+
+// boundariesBucketLayout splits latencies by explicit boundaries. First
+// bucket starts at 0, the last one is unbounded.
+type boundariesBucketLayout struct {
+	boundaries []float64
+}
+
+func NewBoundariesLayout(boundaries []float64) (BucketLayout, error) {
+	if len(boundaries) == 0 {
+		return nil, fmt.Errorf("%w: at least one boundary is required", ErrInvalidLayout)
+	}
+	for i, boundary := range boundaries {
+		if !(boundary > 0) || math.IsInf(boundary, 1) {
+			return nil, fmt.Errorf("%w: boundary must be positive, got %v", ErrInvalidLayout, boundary)
+		}
+		if i > 0 && boundaries[i-1] >= boundary {
+			return nil, fmt.Errorf("%w: boundaries must be increasing, got %v", ErrInvalidLayout, boundaries)
+		}
+	}
+	return &boundariesBucketLayout{boundaries: slices.Clone(boundaries)}, nil
+}
+
+func (l *boundariesBucketLayout) key(latency float64) bucketIndex {
+	return bucketIndex(sort.Search(len(l.boundaries), func(i int) bool {
+		return l.boundaries[i] > latency
+	}))
+}
+
+func (l *boundariesBucketLayout) bucketFrom(index bucketIndex) float64 {
+	if index == 0 {
+		return 0
+	}
+	return l.boundaries[index-1]
+}
+
+func (l *boundariesBucketLayout) bucketTo(index bucketIndex) float64 {
+	if int(index) == len(l.boundaries) {
+		return math.Inf(1)
+	}
+	return l.boundaries[index]
+}
+
+func (l *boundariesBucketLayout) equal(other BucketLayout) bool {
+	otherLayout, ok := other.(*boundariesBucketLayout)
+	return ok && slices.Equal(l.boundaries, otherLayout.boundaries)
+}
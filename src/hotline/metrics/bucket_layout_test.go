@@ -0,0 +1,221 @@
+package metrics_test
+
+import (
+	"hotline/metrics"
+	"math"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket Layout", func() {
+	s := sutlatencyhistogram{}
+
+	Context("exponential", func() {
+		It("keeps default layout for milliseconds", func() {
+			layout, err := metrics.NewExponentialLayout()
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(17, 11, 22)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeInInterval(16.36, 16.37))
+			Expect(bucket.To).Should(BeInInterval(18.82, 18.83))
+		})
+
+		It("collapses sub-threshold latencies into zero bucket", func() {
+			layout, err := metrics.NewExponentialLayout()
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.05, 0.1, 0.2)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("==", 0))
+			Expect(bucket.To).Should(BeNumerically("==", 1))
+		})
+
+		It("distinguishes latencies in seconds with lower zero threshold", func() {
+			layout, err := metrics.NewExponentialLayout(metrics.WithZeroThreshold(0.001))
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.05, 0.1, 0.2)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("<=", 0.1))
+			Expect(bucket.To).Should(BeNumerically(">", 0.1))
+			Expect(bucket.To / bucket.From).Should(BeNumerically("~", 1.15, 0.001))
+		})
+
+		It("starts first bucket over zero threshold at the threshold", func() {
+			layout, err := metrics.NewExponentialLayout(metrics.WithZeroThreshold(0.001))
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.0001, 0.001, 0.001, 0.001)
+
+			bucket := s.computeP99()
+			Expect(bucket.From).Should(BeNumerically("==", 0.001))
+			Expect(bucket.To).Should(BeNumerically(">", 0.001))
+
+			bucket, _ = s.h.ComputePercentile(0.25)
+			Expect(bucket.From).Should(BeNumerically("==", 0))
+			Expect(bucket.To).Should(BeNumerically("==", 0.001))
+		})
+
+		It("narrows buckets by growth factor", func() {
+			layout, err := metrics.NewExponentialLayout(metrics.WithGrowthFactor(1.01))
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(17, 11, 22)
+
+			bucket := s.computeP50()
+			Expect(bucket.To / bucket.From).Should(BeNumerically("~", 1.01, 1e-9))
+			Expect(bucket.From).Should(BeInInterval(16.8, 17))
+		})
+
+		It("derives growth factor from relative error", func() {
+			layout, err := metrics.NewExponentialLayout(metrics.WithRelativeError(0.01))
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(17, 11, 22)
+
+			bucket := s.computeP50()
+			middle := (bucket.From + bucket.To) / 2
+			Expect(math.Abs(middle-17) / 17).Should(BeNumerically("<=", 0.01))
+		})
+
+		It("rejects invalid options", func() {
+			_, err := metrics.NewExponentialLayout(metrics.WithGrowthFactor(1))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewExponentialLayout(metrics.WithRelativeError(1))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewExponentialLayout(metrics.WithZeroThreshold(0))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewExponentialLayout(metrics.WithZeroThreshold(math.NaN()))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewExponentialLayout(metrics.WithScale(21))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewExponentialLayout(metrics.WithScale(-11))
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		})
+
+		It("grows buckets by power of two for scale", func() {
+			layout, err := metrics.NewExponentialLayout(metrics.WithScale(2), metrics.WithZeroThreshold(0.001))
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.3, 0.3, 0.3)
+
+			bucket := s.computeP50()
+			Expect(bucket.To / bucket.From).Should(BeNumerically("~", math.Pow(2, 0.25), 1e-9))
+			Expect(bucket.From).Should(BeNumerically("~", math.Pow(2, -7.0/4), 1e-9))
+		})
+	})
+
+	Context("linear", func() {
+		It("splits latencies to buckets of same width", func() {
+			layout, err := metrics.NewLinearLayout(0.25)
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.1, 0.3, 0.6, 0.7)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("==", 0.25))
+			Expect(bucket.To).Should(BeNumerically("==", 0.5))
+
+			bucket = s.computeP99()
+			Expect(bucket.From).Should(BeNumerically("==", 0.5))
+			Expect(bucket.To).Should(BeNumerically("==", 0.75))
+		})
+
+		It("uses split latencies inside linear buckets", func() {
+			layout, err := metrics.NewLinearLayout(100)
+			Expect(err).NotTo(HaveOccurred())
+			s.h = metrics.NewLatencyHistogram([]float64{150}, metrics.WithBucketLayout(layout))
+			s.fillLatencies(110, 120, 160, 170)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("==", 100))
+			Expect(bucket.To).Should(BeNumerically("==", 150))
+		})
+
+		It("puts negative latencies into first bucket", func() {
+			layout, err := metrics.NewLinearLayout(0.25)
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(-1, -0.5, -0.2)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("==", 0))
+			Expect(bucket.To).Should(BeNumerically("==", 0.25))
+		})
+
+		It("composes histograms of same width", func() {
+			layout, err := metrics.NewLinearLayout(0.25)
+			Expect(err).NotTo(HaveOccurred())
+			same, err := metrics.NewLinearLayout(0.25)
+			Expect(err).NotTo(HaveOccurred())
+			wider, err := metrics.NewLinearLayout(0.5)
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+
+			Expect(s.h.Merge(metrics.NewLatencyHistogram(nil, metrics.WithBucketLayout(same)))).To(Succeed())
+			Expect(s.h.Merge(metrics.NewLatencyHistogram(nil, metrics.WithBucketLayout(wider)))).To(
+				MatchError(metrics.ErrIncompatibleHistograms))
+		})
+
+		It("rejects non positive width", func() {
+			_, err := metrics.NewLinearLayout(0)
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		})
+	})
+
+	Context("boundaries", func() {
+		It("splits latencies by explicit boundaries", func() {
+			layout, err := metrics.NewBoundariesLayout([]float64{0.1, 0.5, 1})
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.05, 0.2, 0.3, 0.7)
+
+			bucket := s.computeP50()
+			Expect(bucket.From).Should(BeNumerically("==", 0.1))
+			Expect(bucket.To).Should(BeNumerically("==", 0.5))
+
+			bucket, _ = s.h.ComputePercentile(0.1)
+			Expect(bucket.From).Should(BeNumerically("==", 0))
+			Expect(bucket.To).Should(BeNumerically("==", 0.1))
+		})
+
+		It("puts latencies over the last boundary to unbounded bucket", func() {
+			layout, err := metrics.NewBoundariesLayout([]float64{0.1, 0.5, 1})
+			Expect(err).NotTo(HaveOccurred())
+			s.forHistogramWithLayout(layout)
+			s.fillLatencies(0.05, 1, 30)
+
+			bucket := s.computeP99()
+			Expect(bucket.From).Should(BeNumerically("==", 1))
+			Expect(math.IsInf(bucket.To, 1)).To(BeTrue())
+		})
+
+		It("rejects empty, unsorted or non positive boundaries", func() {
+			_, err := metrics.NewBoundariesLayout(nil)
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewBoundariesLayout([]float64{0.5, 0.1})
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewBoundariesLayout([]float64{0.1, 0.1})
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+
+			_, err = metrics.NewBoundariesLayout([]float64{0, 0.1})
+			Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		})
+	})
+})
+
+func (s *sutlatencyhistogram) forHistogramWithLayout(layout metrics.BucketLayout) {
+	s.h = metrics.NewLatencyHistogram(nil, metrics.WithBucketLayout(layout))
+}
@@ -72,6 +72,56 @@ func (c *bucketedCounters) CreateSplit(key bucketIndex, splits []float64) {
 	}
 }
 
+// Merge adds counters of other. Split buckets must be created with same
+// split latencies in both counters.
+func (c *bucketedCounters) Merge(other *bucketedCounters) {
+	for key, bucket := range other.buckets {
+		own, found := c.buckets[key]
+		if !found {
+			// split buckets are created in both counters, missing bucket
+			// has no splits
+			own = &bucketCounter{key: key}
+			c.buckets[key] = own
+		}
+		own.counter += bucket.counter
+		for i, split := range bucket.splits {
+			own.splits[i].counter += split.counter
+		}
+	}
+}
+
+// CanSubtract checks all counters of other are covered by c.
+func (c *bucketedCounters) CanSubtract(other *bucketedCounters) bool {
+	for key, bucket := range other.buckets {
+		own, found := c.buckets[key]
+		if !found || own.counter < bucket.counter {
+			return false
+		}
+		for i, split := range bucket.splits {
+			if own.splits[i].counter < split.counter {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Subtract removes counters of other, caller checks CanSubtract first, so
+// every bucket of other is found in c. Emptied buckets without splits are
+// removed.
+func (c *bucketedCounters) Subtract(other *bucketedCounters) {
+	for key, bucket := range other.buckets {
+		own := c.buckets[key]
+		own.counter -= bucket.counter
+		for i, split := range bucket.splits {
+			own.splits[i].counter -= split.counter
+		}
+		if len(own.splits) == 0 && own.counter == 0 {
+			delete(c.buckets, key)
+		}
+	}
+}
+
 func (c *bucketedCounters) GetCounter(index bucketIndex) *bucketCounter {
 	return c.buckets[index]
 }
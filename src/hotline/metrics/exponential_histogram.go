@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ExponentialBuckets are counts of exponential histogram in form of OTLP
+// exponential histogram. Counts[i] is count of bucket with index Offset+i,
+// zero bucket counts latencies below ZeroThreshold.
+type ExponentialBuckets struct {
+	Scale         int32
+	ZeroThreshold float64
+	ZeroCount     uint64
+	Offset        int32
+	Counts        []uint64
+}
+
+// ExponentialHistogram counts latencies in buckets growing by factor
+// 2^(2^-scale), matching OTLP exponential histogram. Whenever buckets would
+// span more than max size, scale is reduced on insert, merging neighbouring
+// buckets as OTel SDK does, so memory stays bounded by max size. Buckets
+// include lower bound while OTLP buckets include upper one, they differ
+// only for latencies exactly at bucket bounds.
+type ExponentialHistogram struct {
+	scale         int32
+	maxSize       int32
+	zeroThreshold float64
+	zeroCount     uint64
+	offset        int32
+	counts        []uint64
+}
+
+// NewExponentialHistogram creates histogram of the highest scale, which is
+// reduced once latencies do not fit maxSize buckets. Scale must be in
+// [-10, 20] and maxSize at least 2.
+func NewExponentialHistogram(scale int32, maxSize int, zeroThreshold float64) (*ExponentialHistogram, error) {
+	if scale < minScale || scale > maxScale {
+		return nil, fmt.Errorf("%w: scale must be in [%d, %d], got %d", ErrInvalidLayout, minScale, maxScale, scale)
+	}
+	if maxSize < 2 || maxSize > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: max size must be at least 2, got %d", ErrInvalidLayout, maxSize)
+	}
+	if !(zeroThreshold > 0) || math.IsInf(zeroThreshold, 1) {
+		return nil, fmt.Errorf("%w: zero threshold must be positive, got %v", ErrInvalidLayout, zeroThreshold)
+	}
+	return &ExponentialHistogram{
+		scale:         scale,
+		maxSize:       int32(maxSize),
+		zeroThreshold: zeroThreshold,
+	}, nil
+}
+
+func (h *ExponentialHistogram) Add(latency float64) {
+	if !(latency >= h.zeroThreshold) {
+		h.zeroCount++
+		return
+	}
+	index := int32(math.Floor(math.Log2(latency) * math.Exp2(float64(h.scale))))
+	index >>= h.fit(index, index)
+	h.counts[index-h.offset]++
+}
+
+// Merge adds all latencies of other histogram, reducing scale to the lower
+// one of both histograms and further until buckets fit max size. Histograms
+// must share zero threshold.
+func (h *ExponentialHistogram) Merge(other *ExponentialHistogram) error {
+	if h.zeroThreshold != other.zeroThreshold {
+		return fmt.Errorf("%w: zero thresholds %v and %v differ", ErrIncompatibleHistograms, h.zeroThreshold, other.zeroThreshold)
+	}
+	h.zeroCount += other.zeroCount
+	if len(other.counts) == 0 {
+		return nil
+	}
+	if other.scale < h.scale {
+		h.downscale(h.scale - other.scale)
+	}
+	shift := other.scale - h.scale
+	shift += h.fit(other.offset>>shift, other.last()>>shift)
+	for i, count := range other.counts {
+		h.counts[(other.offset+int32(i))>>shift-h.offset] += count
+	}
+	return nil
+}
+
+// ExponentialBuckets exports copy of bucket counts.
+func (h *ExponentialHistogram) ExponentialBuckets() ExponentialBuckets {
+	return ExponentialBuckets{
+		Scale:         h.scale,
+		ZeroThreshold: h.zeroThreshold,
+		ZeroCount:     h.zeroCount,
+		Offset:        h.offset,
+		Counts:        slices.Clone(h.counts),
+	}
+}
+
+// last is index of the last bucket, caller checks counts are not empty.
+func (h *ExponentialHistogram) last() int32 {
+	return h.offset + int32(len(h.counts)) - 1
+}
+
+// fit reduces scale until present buckets together with buckets from low to
+// high fit max size and extends counts to cover them. Returns by how much
+// scale was reduced, so caller can shift indexes of the previous scale.
+func (h *ExponentialHistogram) fit(low int32, high int32) int32 {
+	if len(h.counts) > 0 {
+		low = min(low, h.offset)
+		high = max(high, h.last())
+	}
+	shift := int32(0)
+	for h.scale-shift > minScale && (high>>shift)-(low>>shift) >= h.maxSize {
+		shift++
+	}
+	h.downscale(shift)
+	low, high = low>>shift, high>>shift
+
+	if len(h.counts) > 0 && low == h.offset && high == h.last() {
+		return shift
+	}
+	counts := make([]uint64, high-low+1)
+	if len(h.counts) > 0 {
+		copy(counts[h.offset-low:], h.counts)
+	}
+	h.offset, h.counts = low, counts
+	return shift
+}
+
+// downscale reduces scale by shift, merging every 2^shift neighbouring
+// buckets into one.
+func (h *ExponentialHistogram) downscale(shift int32) {
+	if shift == 0 {
+		return
+	}
+	h.scale -= shift
+	if len(h.counts) == 0 {
+		return
+	}
+	offset := h.offset >> shift
+	counts := make([]uint64, h.last()>>shift-offset+1)
+	for i, count := range h.counts {
+		counts[(h.offset+int32(i))>>shift-offset] += count
+	}
+	h.offset, h.counts = offset, counts
+}
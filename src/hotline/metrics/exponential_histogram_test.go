@@ -0,0 +1,176 @@
+package metrics_test
+
+import (
+	"hotline/metrics"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exponential Histogram", func() {
+	s := sutexponentialhistogram{}
+
+	It("counts latencies in buckets of scale", func() {
+		s.forHistogram(0, 160)
+		s.fillLatencies(0.0001, 0.3, 0.3, 1.5, 5)
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(metrics.ExponentialBuckets{
+			Scale:         0,
+			ZeroThreshold: 0.001,
+			ZeroCount:     1,
+			Offset:        -2,
+			Counts:        []uint64{2, 0, 1, 0, 1},
+		}))
+	})
+
+	It("exports no buckets of an empty histogram", func() {
+		s.forHistogram(0, 160)
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(metrics.ExponentialBuckets{
+			Scale:         0,
+			ZeroThreshold: 0.001,
+		}))
+	})
+
+	It("extends buckets to lower latencies", func() {
+		s.forHistogram(0, 160)
+		s.fillLatencies(5, 0.3)
+
+		Expect(s.h.ExponentialBuckets().Offset).To(Equal(int32(-2)))
+		Expect(s.h.ExponentialBuckets().Counts).To(Equal([]uint64{1, 0, 0, 0, 1}))
+	})
+
+	It("downscales on insert once buckets exceed max size", func() {
+		s.forHistogram(0, 2)
+		s.fillLatencies(0.0001, 0.3, 0.3, 1.5, 5)
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(metrics.ExponentialBuckets{
+			Scale:         -2,
+			ZeroThreshold: 0.001,
+			ZeroCount:     1,
+			Offset:        -1,
+			Counts:        []uint64{2, 2},
+		}))
+	})
+
+	It("bounds buckets of wide range at the highest scale", func() {
+		s.forHistogram(20, 160)
+		s.fillLatencies(0.001, 30)
+
+		buckets := s.h.ExponentialBuckets()
+		Expect(len(buckets.Counts)).To(BeNumerically("<=", 160))
+		Expect(buckets.Scale).To(Equal(int32(3)))
+		Expect(buckets.Counts[0] + buckets.Counts[len(buckets.Counts)-1]).To(Equal(uint64(2)))
+	})
+
+	It("keeps at most max size buckets for any latencies", func() {
+		s.forHistogram(20, 160)
+		for latency := 0.001; latency < 1000; latency *= 1.001 {
+			s.h.Add(latency)
+		}
+
+		Expect(len(s.h.ExponentialBuckets().Counts)).To(BeNumerically("<=", 160))
+	})
+
+	It("merges histograms at the lower scale", func() {
+		s.forHistogram(0, 160)
+		s.fillLatencies(0.3, 5)
+		other := newExponentialHistogram(-1, 160)
+		other.Add(0.0001)
+		other.Add(1.5)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(metrics.ExponentialBuckets{
+			Scale:         -1,
+			ZeroThreshold: 0.001,
+			ZeroCount:     1,
+			Offset:        -1,
+			Counts:        []uint64{1, 1, 1},
+		}))
+		Expect(other.ExponentialBuckets().Scale).To(Equal(int32(-1)))
+	})
+
+	It("merging into an empty histogram takes scale of other", func() {
+		s.forHistogram(0, 160)
+		other := newExponentialHistogram(-1, 160)
+		other.Add(1.5)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(other.ExponentialBuckets()))
+	})
+
+	It("downscales other histogram of higher scale while merging", func() {
+		s.forHistogram(-1, 160)
+		s.fillLatencies(1.5)
+		other := newExponentialHistogram(0, 160)
+		other.Add(0.3)
+		other.Add(5)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+
+		Expect(s.h.ExponentialBuckets()).To(Equal(metrics.ExponentialBuckets{
+			Scale:         -1,
+			ZeroThreshold: 0.001,
+			Offset:        -1,
+			Counts:        []uint64{1, 1, 1},
+		}))
+		Expect(other.ExponentialBuckets().Scale).To(BeZero())
+	})
+
+	It("downscales merged histogram exceeding max size", func() {
+		s.forHistogram(0, 2)
+		s.fillLatencies(0.3)
+		other := newExponentialHistogram(0, 2)
+		other.Add(5)
+		other.Add(0.0001)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+		Expect(s.h.Merge(newExponentialHistogram(0, 2))).To(Succeed())
+
+		buckets := s.h.ExponentialBuckets()
+		Expect(buckets.Scale).To(Equal(int32(-2)))
+		Expect(buckets.Counts).To(Equal([]uint64{1, 1}))
+		Expect(buckets.ZeroCount).To(Equal(uint64(1)))
+	})
+
+	It("refuses to merge histograms with different zero thresholds", func() {
+		s.forHistogram(0, 160)
+		other, err := metrics.NewExponentialHistogram(0, 160, 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(s.h.Merge(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+	})
+
+	It("rejects invalid settings", func() {
+		_, err := metrics.NewExponentialHistogram(21, 160, 0.001)
+		Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		_, err = metrics.NewExponentialHistogram(-11, 160, 0.001)
+		Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		_, err = metrics.NewExponentialHistogram(0, 1, 0.001)
+		Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+		_, err = metrics.NewExponentialHistogram(0, 160, 0)
+		Expect(err).To(MatchError(metrics.ErrInvalidLayout))
+	})
+})
+
+type sutexponentialhistogram struct {
+	h *metrics.ExponentialHistogram
+}
+
+func (s *sutexponentialhistogram) forHistogram(scale int32, maxSize int) {
+	s.h = newExponentialHistogram(scale, maxSize)
+}
+
+func (s *sutexponentialhistogram) fillLatencies(latencies ...float64) {
+	for _, latency := range latencies {
+		s.h.Add(latency)
+	}
+}
+
+func newExponentialHistogram(scale int32, maxSize int) *metrics.ExponentialHistogram {
+	h, err := metrics.NewExponentialHistogram(scale, maxSize, 0.001)
+	Expect(err).NotTo(HaveOccurred())
+	return h
+}
@@ -1,24 +1,46 @@
 package metrics
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"slices"
 	"unsafe"
 )
 
+var (
+	ErrIncompatibleHistograms = errors.New("incompatible histograms")
+	ErrNegativeCount          = errors.New("subtraction would make counts negative")
+)
+
 type LatencyHistogram struct {
-	buckets     *bucketedCounters
-	layout      *exponentialBucketLayout
-	splitLength int
+	buckets        *bucketedCounters
+	layout         BucketLayout
+	splitLength    int
+	splitLatencies []float64
+}
+
+type LatencyHistogramOption func(*LatencyHistogram)
+
+// WithBucketLayout replaces default exponential layout, which suits
+// latencies in milliseconds.
+func WithBucketLayout(layout BucketLayout) LatencyHistogramOption {
+	return func(h *LatencyHistogram) {
+		h.layout = layout
+	}
 }
 
-func NewLatencyHistogram(splitLatencies []float64) *LatencyHistogram {
+func NewLatencyHistogram(splitLatencies []float64, opts ...LatencyHistogramOption) *LatencyHistogram {
 	h := &LatencyHistogram{
 		buckets:     newBucketedCounters(),
 		layout:      newExponentialLayout(),
 		splitLength: len(splitLatencies),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
 	slices.Sort(splitLatencies)
+	h.splitLatencies = slices.Compact(slices.Clone(splitLatencies))
 	latenciesByKey := make(map[bucketIndex][]float64)
 	for _, splitLatency := range splitLatencies {
 		key := h.layout.key(splitLatency)
@@ -94,45 +116,44 @@ func (h *LatencyHistogram) Add(latency float64) {
 	h.buckets.Add(key, latency)
 }
 
-func (h *LatencyHistogram) SizeInBytes() int {
-	sizeOfSplit := int(unsafe.Sizeof(&splitCounter{}))
-	h.buckets.SizeInBytes()
-	return h.buckets.SizeInBytes() +
-		(h.splitLength * sizeOfSplit)
-}
-
-type exponentialBucketLayout struct {
-	growthFactor        float64
-	growthDivisor       float64
-	zeroBucketThreshold float64
-}
-
-func newExponentialLayout() *exponentialBucketLayout {
-	growthFactor := 1.15
-	return &exponentialBucketLayout{
-		growthFactor:        growthFactor,
-		growthDivisor:       math.Log(growthFactor),
-		zeroBucketThreshold: 1.0,
+// Merge adds all latencies of other histogram. Histograms must share
+// bucket layout and split latencies.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) error {
+	if err := h.compatible(other); err != nil {
+		return err
 	}
+	h.buckets.Merge(other.buckets)
+	return nil
 }
 
-func (l *exponentialBucketLayout) key(latency float64) bucketIndex {
-	if latency < l.zeroBucketThreshold {
-		return bucketIndex(0)
+// Subtract removes latencies of other histogram, which must have been
+// merged into h before. Histograms must share bucket layout and split
+// latencies.
+func (h *LatencyHistogram) Subtract(other *LatencyHistogram) error {
+	if err := h.compatible(other); err != nil {
+		return err
+	}
+	if !h.buckets.CanSubtract(other.buckets) {
+		return ErrNegativeCount
 	}
-	return bucketIndex(math.Floor(math.Log(latency) / l.growthDivisor))
+	h.buckets.Subtract(other.buckets)
+	return nil
 }
 
-func (l *exponentialBucketLayout) bucketFrom(index bucketIndex) float64 {
-	if index == 0 {
-		return 0
+func (h *LatencyHistogram) compatible(other *LatencyHistogram) error {
+	if !h.layout.equal(other.layout) {
+		return fmt.Errorf("%w: bucket layouts differ", ErrIncompatibleHistograms)
+	}
+	if !slices.Equal(h.splitLatencies, other.splitLatencies) {
+		return fmt.Errorf("%w: split latencies %v and %v differ",
+			ErrIncompatibleHistograms, h.splitLatencies, other.splitLatencies)
 	}
-	return math.Pow(l.growthFactor, float64(index))
+	return nil
 }
 
-func (l *exponentialBucketLayout) bucketTo(index bucketIndex) float64 {
-	if index == 0 {
-		return 1
-	}
-	return math.Pow(l.growthFactor, float64(index+1))
+func (h *LatencyHistogram) SizeInBytes() int {
+	sizeOfSplit := int(unsafe.Sizeof(&splitCounter{}))
+	h.buckets.SizeInBytes()
+	return h.buckets.SizeInBytes() +
+		(h.splitLength * sizeOfSplit)
 }
@@ -116,6 +116,140 @@ var _ = Describe("LatencyMs Histogram", func() {
 	})
 })
 
+var _ = Describe("LatencyMs Histogram composition", func() {
+	s := sutlatencyhistogram{}
+
+	It("merged histogram equals histogram of all latencies", func() {
+		s.forEmptyHistogramWithSplit(1000)
+		s.fillLatencies(500, 1000, 1000)
+		other := metrics.NewLatencyHistogram([]float64{1000})
+		other.Add(1000)
+		other.Add(1000)
+		other.Add(1900)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+
+		bucket := s.computeP50()
+		Expect(bucket.From).Should(BeInInterval(942, 943))
+		Expect(bucket.To).Should(BeNumerically("==", 1000))
+		_, count := s.h.ComputePercentile(0.5)
+		Expect(count).To(BeNumerically("==", 6))
+	})
+
+	It("merging leaves other histogram untouched", func() {
+		s.forEmptyHistogram()
+		other := metrics.NewLatencyHistogram(nil)
+		other.Add(17)
+		other.Add(11)
+		other.Add(22)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+		Expect(s.h.Merge(other)).To(Succeed())
+
+		_, count := other.ComputePercentile(0.5)
+		Expect(count).To(BeNumerically("==", 3))
+		_, count = s.h.ComputePercentile(0.5)
+		Expect(count).To(BeNumerically("==", 6))
+	})
+
+	It("subtracting merged histogram restores percentiles", func() {
+		s.forEmptyHistogramWithSplit(1000)
+		s.fillLatencies(17, 11, 22)
+		other := metrics.NewLatencyHistogram([]float64{1000})
+		other.Add(1000)
+		other.Add(1900)
+		other.Add(1900)
+		other.Add(1900)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+		Expect(s.computeP50().From).Should(BeNumerically(">", 900))
+
+		Expect(s.h.Subtract(other)).To(Succeed())
+		bucket := s.computeP50()
+		Expect(bucket.From).Should(BeInInterval(16.36, 16.37))
+		Expect(bucket.To).Should(BeInInterval(18.82, 18.83))
+		_, count := s.h.ComputePercentile(0.5)
+		Expect(count).To(BeNumerically("==", 3))
+	})
+
+	It("subtracting everything empties histogram", func() {
+		s.forEmptyHistogram()
+		s.fillLatencies(17, 11, 22)
+
+		Expect(s.h.Subtract(s.h)).To(Succeed())
+
+		bucket, count := s.h.ComputePercentile(0.5)
+		Expect(count).To(BeZero())
+		Expect(bucket).To(Equal(metrics.Bucket{}))
+	})
+
+	It("refuses to subtract latencies not in histogram", func() {
+		s.forEmptyHistogram()
+		s.fillLatencies(17, 11)
+		other := metrics.NewLatencyHistogram(nil)
+		other.Add(17)
+		other.Add(1900)
+
+		Expect(s.h.Subtract(other)).To(MatchError(metrics.ErrNegativeCount))
+		_, count := s.h.ComputePercentile(0.5)
+		Expect(count).To(BeNumerically("==", 2))
+	})
+
+	It("refuses to subtract more latencies of bucket than histogram holds", func() {
+		s.forEmptyHistogram()
+		s.fillLatencies(17)
+		other := metrics.NewLatencyHistogram(nil)
+		other.Add(17)
+		other.Add(17)
+
+		Expect(s.h.Subtract(other)).To(MatchError(metrics.ErrNegativeCount))
+	})
+
+	It("refuses to subtract more latencies of split bucket than histogram holds", func() {
+		s.forEmptyHistogramWithSplit(1000)
+		s.fillLatencies(1000)
+		other := metrics.NewLatencyHistogram([]float64{1000})
+		other.Add(1000)
+		other.Add(1000)
+
+		Expect(s.h.Subtract(other)).To(MatchError(metrics.ErrNegativeCount))
+	})
+
+	It("refuses to compose histograms with different split latencies", func() {
+		s.forEmptyHistogramWithSplit(1000)
+		other := metrics.NewLatencyHistogram([]float64{2000})
+
+		Expect(s.h.Merge(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+		Expect(s.h.Subtract(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+	})
+
+	It("refuses to compose histograms with different layouts", func() {
+		s.forEmptyHistogram()
+		seconds, err := metrics.NewExponentialLayout(metrics.WithZeroThreshold(0.001))
+		Expect(err).NotTo(HaveOccurred())
+		linear, err := metrics.NewLinearLayout(10)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, layout := range []metrics.BucketLayout{seconds, linear} {
+			other := metrics.NewLatencyHistogram(nil, metrics.WithBucketLayout(layout))
+			Expect(s.h.Merge(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+		}
+	})
+
+	It("composes histograms with equal layouts", func() {
+		first, err := metrics.NewBoundariesLayout([]float64{1, 2})
+		Expect(err).NotTo(HaveOccurred())
+		second, err := metrics.NewBoundariesLayout([]float64{1, 2})
+		Expect(err).NotTo(HaveOccurred())
+		s.forHistogramWithLayout(first)
+		other := metrics.NewLatencyHistogram(nil, metrics.WithBucketLayout(second))
+		other.Add(1.5)
+
+		Expect(s.h.Merge(other)).To(Succeed())
+		Expect(s.h.Subtract(other)).To(Succeed())
+	})
+})
+
 type sutlatencyhistogram struct {
 	h *metrics.LatencyHistogram
 }
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"hash/maphash"
+	"runtime"
+	"sync"
+)
+
+// ShardedStore keeps an accumulator per series key in lock striped shards,
+// so writers of different series rarely contend on the same lock. Writers
+// buffer values locally in a StoreBatch and commit them taking every shard
+// lock at most once.
+type ShardedStore[K comparable, T any, A Accumulator[T]] struct {
+	seed   maphash.Seed
+	shards []*storeShard[K, A]
+	create func(key K) A
+}
+
+type storeShard[K comparable, A any] struct {
+	mu     sync.Mutex
+	series map[K]A
+}
+
+// NewShardedStore creates store with given number of shards, non-positive
+// count defaults to 4 shards per available CPU. create is called under
+// shard lock for the first value of every series.
+func NewShardedStore[K comparable, T any, A Accumulator[T]](shardCount int, create func(key K) A) *ShardedStore[K, T, A] {
+	if shardCount <= 0 {
+		shardCount = 4 * runtime.GOMAXPROCS(0)
+	}
+	shards := make([]*storeShard[K, A], shardCount)
+	for i := range shards {
+		shards[i] = &storeShard[K, A]{series: make(map[K]A)}
+	}
+	return &ShardedStore[K, T, A]{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		create: create,
+	}
+}
+
+func (s *ShardedStore[K, T, A]) shardIndex(key K) int {
+	return int(maphash.Comparable(s.seed, key) % uint64(len(s.shards)))
+}
+
+// Add adds single value, prefer StoreBatch for multiple values.
+func (s *ShardedStore[K, T, A]) Add(key K, value T) {
+	shard := s.shards[s.shardIndex(key)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	s.accumulatorOf(shard, key).Add(value)
+}
+
+func (s *ShardedStore[K, T, A]) accumulatorOf(shard *storeShard[K, A], key K) A {
+	acc, found := shard.series[key]
+	if !found {
+		acc = s.create(key)
+		shard.series[key] = acc
+	}
+	return acc
+}
+
+// Drain removes all series from the store and returns them.
+func (s *ShardedStore[K, T, A]) Drain() map[K]A {
+	return s.DrainWith(nil)
+}
+
+// DrainWith removes all series like Drain, calling reset while locks of all
+// shards are held, so state reset together with the store never misses or
+// double counts values committed concurrently. reset must not use the store.
+func (s *ShardedStore[K, T, A]) DrainWith(reset func()) map[K]A {
+	shardSeries := make([]map[K]A, len(s.shards))
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+	for index, shard := range s.shards {
+		shardSeries[index] = shard.series
+		shard.series = make(map[K]A, len(shard.series))
+	}
+	if reset != nil {
+		reset()
+	}
+	for _, shard := range s.shards {
+		shard.mu.Unlock()
+	}
+
+	drained := make(map[K]A)
+	for _, series := range shardSeries {
+		for key, acc := range series {
+			drained[key] = acc
+		}
+	}
+	return drained
+}
+
+// Range calls f for every series, holding lock of the series shard.
+func (s *ShardedStore[K, T, A]) Range(f func(key K, acc A)) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for key, acc := range shard.series {
+			f(key, acc)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// DeleteFunc removes series for which del returns true, holding lock of the
+// series shard.
+func (s *ShardedStore[K, T, A]) DeleteFunc(del func(key K, acc A) bool) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for key, acc := range shard.series {
+			if del(key, acc) {
+				delete(shard.series, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+func (s *ShardedStore[K, T, A]) Len() int {
+	length := 0
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		length += len(shard.series)
+		shard.mu.Unlock()
+	}
+	return length
+}
+
+// NewBatch creates local buffer of values. Batch is not safe for
+// concurrent use, every goroutine uses its own batch.
+func (s *ShardedStore[K, T, A]) NewBatch() *StoreBatch[K, T, A] {
+	return &StoreBatch[K, T, A]{
+		store:   s,
+		pending: make([][]pendingValue[K, T], len(s.shards)),
+	}
+}
+
+type pendingValue[K comparable, T any] struct {
+	key   K
+	value T
+}
+
+type StoreBatch[K comparable, T any, A Accumulator[T]] struct {
+	store   *ShardedStore[K, T, A]
+	pending [][]pendingValue[K, T]
+}
+
+func (b *StoreBatch[K, T, A]) Add(key K, value T) {
+	index := b.store.shardIndex(key)
+	b.pending[index] = append(b.pending[index], pendingValue[K, T]{key: key, value: value})
+}
+
+// Commit adds buffered values to the store. Batch is empty afterward and
+// can be reused.
+func (b *StoreBatch[K, T, A]) Commit() {
+	b.CommitWith(nil)
+}
+
+// CommitWith adds buffered values like Commit, admitting series not in the
+// store yet. admit is called under shard lock for every value of such series,
+// so admission is atomic with DrainWith reset, and returns key the value is
+// added to. Values admitted to other keys are added after all shards are
+// committed, without admission.
+func (b *StoreBatch[K, T, A]) CommitWith(admit func(key K) K) {
+	var redirected []pendingValue[K, T]
+	for index, values := range b.pending {
+		if len(values) == 0 {
+			continue
+		}
+		shard := b.store.shards[index]
+		shard.mu.Lock()
+		for _, pending := range values {
+			acc, found := shard.series[pending.key]
+			if !found {
+				if admit != nil {
+					if key := admit(pending.key); key != pending.key {
+						redirected = append(redirected, pendingValue[K, T]{key: key, value: pending.value})
+						continue
+					}
+				}
+				acc = b.store.create(pending.key)
+				shard.series[pending.key] = acc
+			}
+			acc.Add(pending.value)
+		}
+		shard.mu.Unlock()
+
+		clear(values)
+		b.pending[index] = values[:0]
+	}
+
+	for _, pending := range redirected {
+		b.Add(pending.key, pending.value)
+	}
+	if len(redirected) > 0 {
+		b.Commit()
+	}
+}
@@ -0,0 +1,304 @@
+package metrics_test
+
+import (
+	"fmt"
+	"hotline/metrics"
+	"hotline/metrics/tdigest"
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sharded Store", func() {
+	s := sutshardedstore{}
+
+	It("is empty when created", func() {
+		s.forStore(4)
+		Expect(s.store.Len()).To(BeZero())
+		Expect(s.store.Drain()).To(BeEmpty())
+	})
+
+	It("creates accumulator per series key", func() {
+		s.forStore(4)
+		s.store.Add("a", 1)
+		s.store.Add("b", 2)
+		s.store.Add("a", 3)
+
+		drained := s.store.Drain()
+		Expect(drained).To(HaveLen(2))
+		Expect(drained["a"].values).To(Equal([]float64{1, 3}))
+		Expect(drained["b"].values).To(Equal([]float64{2}))
+		Expect(s.created).To(ConsistOf("a", "b"))
+	})
+
+	It("removes all series when drained", func() {
+		s.forStore(4)
+		s.store.Add("a", 1)
+
+		Expect(s.store.Drain()).To(HaveLen(1))
+		Expect(s.store.Len()).To(BeZero())
+		Expect(s.store.Drain()).To(BeEmpty())
+	})
+
+	It("resets state while draining", func() {
+		s.forStore(4)
+		s.store.Add("a", 1)
+		s.store.Add("b", 2)
+
+		resets := 0
+		drained := s.store.DrainWith(func() { resets++ })
+
+		Expect(resets).To(Equal(1))
+		Expect(drained).To(HaveLen(2))
+		Expect(s.store.Len()).To(BeZero())
+	})
+
+	It("buffers batch values until commit", func() {
+		s.forStore(4)
+		batch := s.store.NewBatch()
+		batch.Add("a", 1)
+		batch.Add("b", 2)
+		Expect(s.store.Len()).To(BeZero())
+
+		batch.Commit()
+
+		Expect(s.store.Len()).To(Equal(2))
+	})
+
+	It("reuses committed batch", func() {
+		s.forStore(4)
+		batch := s.store.NewBatch()
+		batch.Add("a", 1)
+		batch.Commit()
+		batch.Add("a", 2)
+		batch.Commit()
+
+		drained := s.store.Drain()
+		Expect(drained["a"].values).To(Equal([]float64{1, 2}))
+	})
+
+	It("admits only series not in store on commit", func() {
+		s.forStore(4)
+		s.store.Add("a", 1)
+		batch := s.store.NewBatch()
+		batch.Add("a", 2)
+		batch.Add("b", 3)
+		batch.Add("b", 4)
+
+		var admitted []string
+		batch.CommitWith(func(key string) string {
+			admitted = append(admitted, key)
+			return key
+		})
+
+		Expect(admitted).To(Equal([]string{"b"}))
+		drained := s.store.Drain()
+		Expect(drained["a"].values).To(Equal([]float64{1, 2}))
+		Expect(drained["b"].values).To(Equal([]float64{3, 4}))
+	})
+
+	It("adds values of rejected series to admitted key", func() {
+		s.forStore(4)
+		batch := s.store.NewBatch()
+		batch.Add("a", 1)
+		batch.Add("b", 2)
+		batch.Add("c", 3)
+
+		batch.CommitWith(func(key string) string {
+			if key == "a" {
+				return key
+			}
+			return "other"
+		})
+
+		drained := s.store.Drain()
+		Expect(drained).To(HaveLen(2))
+		Expect(drained["a"].values).To(Equal([]float64{1}))
+		Expect(drained["other"].values).To(ConsistOf(2.0, 3.0))
+		Expect(s.created).To(ConsistOf("a", "other"))
+	})
+
+	It("admits series atomically with drain reset", func() {
+		s.forStore(8)
+		var mu sync.Mutex
+		admitted := 0
+		admit := func(key string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			if admitted >= 2 {
+				return "overflow"
+			}
+			admitted++
+			return key
+		}
+		reset := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			admitted = 0
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for worker := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				batch := s.store.NewBatch()
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					batch.Add(fmt.Sprintf("series-%d-%d", worker, i%10), 1)
+					batch.CommitWith(admit)
+				}
+			}()
+		}
+		for range 100 {
+			drained := s.store.DrainWith(reset)
+			delete(drained, "overflow")
+			Expect(len(drained)).To(BeNumerically("<=", 2))
+		}
+		close(done)
+		wg.Wait()
+	})
+
+	It("ranges over all series", func() {
+		s.forStore(2)
+		for i := range 10 {
+			s.store.Add(fmt.Sprintf("series-%d", i), float64(i))
+		}
+
+		sum := 0.0
+		s.store.Range(func(_ string, acc *float64ArrAcc) {
+			for _, value := range acc.values {
+				sum += value
+			}
+		})
+		Expect(sum).To(Equal(45.0))
+	})
+
+	It("deletes selected series", func() {
+		s.forStore(2)
+		for i := range 10 {
+			s.store.Add(fmt.Sprintf("series-%d", i), float64(i))
+		}
+
+		s.store.DeleteFunc(func(_ string, acc *float64ArrAcc) bool {
+			return acc.values[0] >= 5
+		})
+
+		drained := s.store.Drain()
+		Expect(drained).To(HaveLen(5))
+		Expect(drained).NotTo(HaveKey("series-5"))
+	})
+
+	It("defaults shard count to available CPUs", func() {
+		s.forStore(0)
+		s.store.Add("a", 1)
+		Expect(s.store.Len()).To(Equal(1))
+	})
+
+	It("keeps all values committed concurrently", func() {
+		s.forStore(8)
+		var wg sync.WaitGroup
+		for worker := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				batch := s.store.NewBatch()
+				for i := range 1000 {
+					batch.Add(fmt.Sprintf("series-%d", i%10), float64(worker))
+					if i%100 == 0 {
+						batch.Commit()
+					}
+				}
+				batch.Commit()
+			}()
+		}
+		wg.Wait()
+
+		total := 0
+		for _, acc := range s.store.Drain() {
+			total += len(acc.values)
+		}
+		Expect(total).To(Equal(8000))
+	})
+})
+
+type sutshardedstore struct {
+	store   *metrics.ShardedStore[string, float64, *float64ArrAcc]
+	mu      sync.Mutex
+	created []string
+}
+
+func (s *sutshardedstore) forStore(shards int) {
+	s.created = nil
+	s.store = metrics.NewShardedStore[string, float64](shards, func(key string) *float64ArrAcc {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.created = append(s.created, key)
+		return newArrAccumulator()
+	})
+}
+
+type digestAcc struct {
+	digest *tdigest.TDigest
+}
+
+func newDigestAcc(string) *digestAcc {
+	return &digestAcc{digest: tdigest.NewTDigestWeightScaled(100, 500)}
+}
+
+func (a *digestAcc) Add(value float64) {
+	a.digest.AddToBuffer(value, 1)
+}
+
+// BenchmarkShardedStoreParallel ingests batches of 500 latencies into 1000
+// series from parallel goroutines. Run with -cpu 1,2,4,8 to see throughput
+// scaling with GOMAXPROCS, compared to a single mutex guarding all series.
+func BenchmarkShardedStoreParallel(b *testing.B) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("series-%d", i)
+	}
+
+	b.Run("sharded", func(b *testing.B) {
+		store := metrics.NewShardedStore[string, float64](0, newDigestAcc)
+		b.RunParallel(func(pb *testing.PB) {
+			randomizer := rand.New(rand.NewPCG(rand.Uint64(), 1))
+			batch := store.NewBatch()
+			for pb.Next() {
+				for range 500 {
+					batch.Add(keys[randomizer.IntN(len(keys))], randomizer.Float64())
+				}
+				batch.Commit()
+			}
+		})
+	})
+
+	b.Run("single-mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		series := make(map[string]*digestAcc)
+		b.RunParallel(func(pb *testing.PB) {
+			randomizer := rand.New(rand.NewPCG(rand.Uint64(), 1))
+			for pb.Next() {
+				mu.Lock()
+				for range 500 {
+					key := keys[randomizer.IntN(len(keys))]
+					acc, found := series[key]
+					if !found {
+						acc = newDigestAcc(key)
+						series[key] = acc
+					}
+					acc.Add(randomizer.Float64())
+				}
+				mu.Unlock()
+			}
+		})
+	})
+}
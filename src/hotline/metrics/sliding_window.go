@@ -1,6 +1,11 @@
 package metrics
 
-import "time"
+import (
+	"hotline/clock"
+	"slices"
+	"sync"
+	"time"
+)
 
 type Window[T any, A Accumulator[T]] struct {
 	StartTime   time.Time
@@ -8,86 +13,305 @@ type Window[T any, A Accumulator[T]] struct {
 	Accumulator A
 }
 
-func (w *Window[T, A]) IsActive(now time.Time) bool {
-	nowSec := now.Unix()
-	startSec := w.StartTime.Unix()
-	endSec := w.EndTime.Unix()
+type Accumulator[T any] interface {
+	Add(value T)
+}
 
-	return nowSec >= startSec && nowSec < endSec
+// Mergeable accumulators can be combined from per step accumulators. Merge
+// fails only for accumulators created with different settings.
+type Mergeable[A any] interface {
+	Merge(other A) error
 }
 
-func (w *Window[T, A]) IsActiveGracePeriod(now time.Time, gracePeriod time.Duration) bool {
-	graceEnd := w.EndTime
-	graceStart := w.EndTime.Add(-gracePeriod)
+// SlidingWindow keeps a ring of per grace period step accumulators covering
+// window Size, rounded up to whole grace periods. Adding a value touches single step, windows are combined from
+// steps on read. Mergeable accumulators are merged, values of other
+// accumulators are kept per step and replayed. SlidingWindow is safe for
+// concurrent use, combined windows are owned by caller.
+type SlidingWindow[T any, A Accumulator[T]] struct {
+	Size        time.Duration
+	GracePeriod time.Duration
+	mu          sync.Mutex
+	steps       []windowStep[T, A]
+	createAcc   func() A
+	mergeable   bool
+	clock       clock.Clock
 
-	nowSec := now.Unix()
-	graceStartSec := graceStart.Unix()
-	graceEndSec := graceEnd.Unix()
+	notifyMu    sync.Mutex
+	onClosed    []func(*Window[T, A])
+	closedUntil time.Time
+	latestStart time.Time
+}
 
-	return nowSec >= graceStartSec && nowSec < graceEndSec
+type windowStep[T any, A Accumulator[T]] struct {
+	start  time.Time
+	acc    A
+	values []T
 }
 
-type Accumulator[T any] interface {
-	Add(value T)
+type SlidingWindowOption func(*slidingWindowOptions)
+
+type slidingWindowOptions struct {
+	clock clock.Clock
 }
 
-type SlidingWindow[T any, A Accumulator[T]] struct {
-	Size        time.Duration
-	GracePeriod time.Duration
-	windows     map[int64]*Window[T, A]
-	createAcc   func() A
+// WithClock sets clock used by Add, Tick and ActiveWindow, system clock is
+// used by default.
+func WithClock(clock clock.Clock) SlidingWindowOption {
+	return func(o *slidingWindowOptions) {
+		o.clock = clock
+	}
 }
 
-func NewSlidingWindow[T any, A Accumulator[T]](createAcc func() A, size time.Duration, gracePeriod time.Duration) *SlidingWindow[T, A] {
+func NewSlidingWindow[T any, A Accumulator[T]](createAcc func() A, size time.Duration, gracePeriod time.Duration, opts ...SlidingWindowOption) *SlidingWindow[T, A] {
+	options := slidingWindowOptions{clock: clock.SystemClock{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	stepCount := max(1, int((size+gracePeriod-1)/gracePeriod))
+	// accumulators of steps are created lazily, only type of A is checked
+	var zero A
+	_, mergeable := any(zero).(Mergeable[A])
 	return &SlidingWindow[T, A]{
 		Size:        size,
 		GracePeriod: gracePeriod,
+		steps:       make([]windowStep[T, A], stepCount),
 		createAcc:   createAcc,
-		windows:     make(map[int64]*Window[T, A]),
+		mergeable:   mergeable,
+		clock:       options.clock,
 	}
 }
 
+// Add adds value at current time of clock, so sliding window itself can be
+// used as accumulator.
+func (w *SlidingWindow[T, A]) Add(value T) {
+	w.AddValue(w.clock.Now(), value)
+}
+
+// Tick advances sliding window to current time of clock.
+func (w *SlidingWindow[T, A]) Tick() {
+	w.Advance(w.clock.Now())
+}
+
+// ActiveWindow returns active window at current time of clock.
+func (w *SlidingWindow[T, A]) ActiveWindow() *Window[T, A] {
+	return w.GetActiveWindow(w.clock.Now())
+}
+
+func (w *SlidingWindow[T, A]) stepAt(start time.Time) *windowStep[T, A] {
+	index := start.UnixNano() / int64(w.GracePeriod) % int64(len(w.steps))
+	if index < 0 {
+		index += int64(len(w.steps))
+	}
+	return &w.steps[index]
+}
+
+// GetActiveWindow combines the single window ending in grace period of now,
+// including values of the step still in progress. Returns nil if no value
+// was added into the window.
 func (w *SlidingWindow[T, A]) GetActiveWindow(now time.Time) *Window[T, A] {
-	if len(w.windows) == 0 {
+	return w.windowEndingAt(now.Truncate(w.GracePeriod).Add(w.GracePeriod))
+}
+
+// HasValues reports whether a value was added into the active window of now,
+// without combining its steps.
+func (w *SlidingWindow[T, A]) HasValues(now time.Time) bool {
+	endTime := now.Truncate(w.GracePeriod).Add(w.GracePeriod)
+	firstStart := endTime.Add(-w.span())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.steps {
+		start := w.steps[i].start
+		if !start.IsZero() && !start.Before(firstStart) && start.Before(endTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCompletedWindow combines the most recently completed window, the one
+// ending at start of grace period of now. Returns nil if no value was added
+// into the window.
+func (w *SlidingWindow[T, A]) GetCompletedWindow(now time.Time) *Window[T, A] {
+	return w.windowEndingAt(now.Truncate(w.GracePeriod))
+}
+
+func (w *SlidingWindow[T, A]) windowEndingAt(endTime time.Time) *Window[T, A] {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.combineWindow(endTime)
+}
+
+func (w *SlidingWindow[T, A]) combineWindow(endTime time.Time) *Window[T, A] {
+	firstStart := endTime.Add(-time.Duration(len(w.steps)) * w.GracePeriod)
+
+	var window *Window[T, A]
+	for start := firstStart; start.Before(endTime); start = start.Add(w.GracePeriod) {
+		step := w.stepAt(start)
+		if !step.start.Equal(start) {
+			continue
+		}
+		if window == nil {
+			window = w.newWindow(endTime)
+		}
+		w.combine(window.Accumulator, step)
+	}
+	return window
+}
+
+func (w *SlidingWindow[T, A]) newWindow(endTime time.Time) *Window[T, A] {
+	return &Window[T, A]{
+		StartTime:   endTime.Add(-w.span()),
+		EndTime:     endTime,
+		Accumulator: w.createAcc(),
+	}
+}
+
+// span is the time covered by steps of the ring.
+func (w *SlidingWindow[T, A]) span() time.Duration {
+	return time.Duration(len(w.steps)) * w.GracePeriod
+}
+
+func (w *SlidingWindow[T, A]) combine(acc A, step *windowStep[T, A]) {
+	if w.mergeable {
+		// steps are created by the same factory, merge can not fail
+		_ = any(acc).(Mergeable[A]).Merge(step.acc)
+		return
+	}
+	for _, value := range step.values {
+		acc.Add(value)
+	}
+}
+
+// OnWindowClosed registers hook called exactly once for every window with
+// values, after Advance or AddValue moves time past its end. Hooks are called
+// in order windows close and may read windows, but must not call Advance or
+// AddValue.
+func (w *SlidingWindow[T, A]) OnWindowClosed(hook func(*Window[T, A])) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onClosed = append(w.onClosed, hook)
+}
+
+// Advance moves time of sliding window to now without adding value, closing
+// windows ending at or before now. Time never moves backwards.
+func (w *SlidingWindow[T, A]) Advance(now time.Time) {
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+
+	w.mu.Lock()
+	closed := w.advance(now)
+	hooks := w.onClosed
+	w.mu.Unlock()
+
+	notifyClosed(hooks, closed)
+}
+
+func (w *SlidingWindow[T, A]) advance(now time.Time) []*Window[T, A] {
+	endTime := now.Truncate(w.GracePeriod)
+	if w.closedUntil.IsZero() {
+		w.closedUntil = endTime
+		return nil
+	}
+	if !endTime.After(w.closedUntil) {
 		return nil
 	}
-	w.pruneObsoleteWindows(now)
-	for _, window := range w.windows {
-		if window.IsActiveGracePeriod(now, w.GracePeriod) {
-			return window
+
+	var closed []*Window[T, A]
+	if len(w.onClosed) > 0 {
+		closed = w.closeWindows(w.closedUntil, endTime)
+	}
+	w.closedUntil = endTime
+	if !w.latestStart.IsZero() && !endTime.Before(w.latestStart.Add(w.span())) {
+		// every step left the ring, recycle all of them in one pass and keep
+		// dropping values older than the ring
+		clear(w.steps)
+		w.latestStart = endTime.Add(-w.GracePeriod)
+	}
+	return closed
+}
+
+// closeWindows combines windows with values ending in (from, to]. Values
+// are never added to steps starting after from, so only windows holding
+// steps still kept in ring are combined and catching up after an idle gap
+// does not combine the whole ring per step.
+func (w *SlidingWindow[T, A]) closeWindows(from time.Time, to time.Time) []*Window[T, A] {
+	span := w.span()
+	var live []*windowStep[T, A]
+	for i := range w.steps {
+		step := &w.steps[i]
+		if !step.start.IsZero() && step.start.Add(span).After(from) {
+			live = append(live, step)
 		}
 	}
-	return nil
+	slices.SortFunc(live, func(a, b *windowStep[T, A]) int {
+		return a.start.Compare(b.start)
+	})
+
+	var closed []*Window[T, A]
+	first := 0
+	for end := from.Add(w.GracePeriod); !end.After(to); end = end.Add(w.GracePeriod) {
+		for first < len(live) && live[first].start.Before(end.Add(-span)) {
+			first++
+		}
+		if first == len(live) {
+			break
+		}
+		window := w.newWindow(end)
+		for _, step := range live[first:] {
+			w.combine(window.Accumulator, step)
+		}
+		closed = append(closed, window)
+	}
+	return closed
 }
 
-func (w *SlidingWindow[T, A]) pruneObsoleteWindows(now time.Time) {
-	for key, window := range w.windows {
-		if !window.IsActive(now) {
-			delete(w.windows, key)
+func notifyClosed[T any, A Accumulator[T]](hooks []func(*Window[T, A]), closed []*Window[T, A]) {
+	for _, window := range closed {
+		for _, hook := range hooks {
+			hook(window)
 		}
 	}
 }
 
+// AddValue advances time to now and adds value into step of now, recycling
+// step of expired window. Values older than any window kept in ring are
+// dropped.
 func (w *SlidingWindow[T, A]) AddValue(now time.Time, value T) {
-	w.pruneObsoleteWindows(now)
-
-	windowStart := now.Truncate(w.GracePeriod)
-	for offset := time.Duration(0); offset <= w.Size; offset += w.GracePeriod {
-		startTime := windowStart.Add(-offset)
-		endTime := startTime.Add(w.Size)
-
-		key := startTime.Unix()
-		_, found := w.windows[key]
-		if !found {
-			w.windows[key] = &Window[T, A]{
-				StartTime:   startTime,
-				EndTime:     endTime,
-				Accumulator: w.createAcc(),
-			}
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+
+	w.mu.Lock()
+	closed := w.advance(now)
+	hooks := w.onClosed
+	w.addValue(now, value)
+	w.mu.Unlock()
+
+	notifyClosed(hooks, closed)
+}
+
+func (w *SlidingWindow[T, A]) addValue(now time.Time, value T) {
+	start := now.Truncate(w.GracePeriod)
+	if !w.latestStart.IsZero() && !start.Add(w.span()).After(w.latestStart) {
+		return
+	}
+	step := w.stepAt(start)
+	if !step.start.Equal(start) {
+		step.start = start
+		step.values = step.values[:0]
+		if w.mergeable {
+			step.acc = w.createAcc()
 		}
 	}
+	if start.After(w.latestStart) {
+		w.latestStart = start
+	}
 
-	for key := range w.windows {
-		w.windows[key].Accumulator.Add(value)
+	if w.mergeable {
+		step.acc.Add(value)
+	} else {
+		step.values = append(step.values, value)
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"hotline/clock"
 	"hotline/metrics"
 	"slices"
+	"sync"
+	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -104,10 +106,300 @@ var _ = Describe("SlidingWindow", func() {
 			Expect(s.windowContains(window, 2345)).To(BeTrue())
 		})
 	})
+
+	Context("window of mergeable accumulators", func() {
+		It("creates accumulators only for steps with values", func() {
+			created := 0
+			window := metrics.NewSlidingWindow(func() *metrics.LatencyHistogram {
+				created++
+				return newHistogramAccumulator()
+			}, time.Minute, 10*time.Second)
+			Expect(created).To(BeZero())
+
+			window.AddValue(clock.ParseTime("2025-02-22T12:04:05Z"), 17)
+			window.AddValue(clock.ParseTime("2025-02-22T12:04:06Z"), 11)
+			Expect(created).To(Equal(1))
+			Expect(window.HasValues(clock.ParseTime("2025-02-22T12:04:50Z"))).To(BeTrue())
+			Expect(window.HasValues(clock.ParseTime("2025-02-22T12:05:00Z"))).To(BeFalse())
+			Expect(created).To(Equal(1))
+		})
+
+		It("combines values of all steps in window", func() {
+			s.forEmptyHistogramSlidingWindow()
+			s.addHistogramValue(17, "2025-02-22T12:04:05Z")
+			s.addHistogramValue(11, "2025-02-22T12:04:15Z")
+			s.addHistogramValue(22, "2025-02-22T12:04:15Z")
+
+			window := s.histogramSlidingWindow.GetActiveWindow(clock.ParseTime("2025-02-22T12:04:50Z"))
+			Expect(window).NotTo(BeNil())
+			bucket, count := window.Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 3))
+			Expect(bucket.From).Should(BeInInterval(16.36, 16.37))
+		})
+
+		It("combined window does not change steps", func() {
+			s.forEmptyHistogramSlidingWindow()
+			s.addHistogramValue(17, "2025-02-22T12:04:05Z")
+			now := clock.ParseTime("2025-02-22T12:04:50Z")
+
+			s.histogramSlidingWindow.GetActiveWindow(now).Accumulator.Add(11)
+
+			_, count := s.histogramSlidingWindow.GetActiveWindow(now).Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 1))
+		})
+
+		It("forgets values of steps recycled by later values", func() {
+			s.forEmptyHistogramSlidingWindow()
+			s.addHistogramValue(17, "2025-02-22T12:04:05Z")
+			s.addHistogramValue(11, "2025-02-22T12:05:05Z")
+
+			window := s.histogramSlidingWindow.GetActiveWindow(clock.ParseTime("2025-02-22T12:05:05Z"))
+			Expect(window).NotTo(BeNil())
+			_, count := window.Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 1))
+		})
+
+		It("drops values older than steps kept in window", func() {
+			s.forEmptyHistogramSlidingWindow()
+			s.addHistogramValue(11, "2025-02-22T12:05:05Z")
+			s.addHistogramValue(17, "2025-02-22T12:04:05Z")
+
+			window := s.histogramSlidingWindow.GetActiveWindow(clock.ParseTime("2025-02-22T12:05:05Z"))
+			_, count := window.Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 1))
+			Expect(s.histogramSlidingWindow.GetActiveWindow(clock.ParseTime("2025-02-22T12:04:05Z"))).To(BeNil())
+		})
+
+		It("keeps values added before unix epoch", func() {
+			s.forEmptyHistogramSlidingWindow()
+			s.addHistogramValue(17, "1969-12-31T23:59:45Z")
+			s.addHistogramValue(23, "1969-12-31T23:59:55Z")
+
+			window := s.histogramSlidingWindow.GetActiveWindow(clock.ParseTime("1969-12-31T23:59:55Z"))
+			_, count := window.Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 2))
+		})
+	})
+
+	Context("window selection", func() {
+		It("returns same window for every time in grace period", func() {
+			s.forEmptySlidingWindow()
+			s.addValue(1234, "2025-02-22T12:03:35Z")
+			s.addValue(2345, "2025-02-22T12:04:05Z")
+
+			windows := s.scrollBySecond("2025-02-22T12:04:00Z", 10)
+			for _, window := range windows {
+				Expect(window.StartTime).To(Equal(clock.ParseTime("2025-02-22T12:03:10Z")))
+				Expect(window.EndTime).To(Equal(clock.ParseTime("2025-02-22T12:04:10Z")))
+				Expect(window.Accumulator.values).To(Equal([]float64{1234, 2345}))
+			}
+		})
+
+		It("completed window excludes step in progress", func() {
+			s.forEmptySlidingWindow()
+			s.addValue(1234, "2025-02-22T12:03:35Z")
+			s.addValue(2345, "2025-02-22T12:04:05Z")
+
+			window := s.getCompletedWindow("2025-02-22T12:04:05Z")
+			Expect(window).NotTo(BeNil())
+			Expect(window.StartTime).To(Equal(clock.ParseTime("2025-02-22T12:03:00Z")))
+			Expect(window.EndTime).To(Equal(clock.ParseTime("2025-02-22T12:04:00Z")))
+			Expect(window.Accumulator.values).To(Equal([]float64{1234}))
+		})
+
+		It("completed window is active window of previous grace period", func() {
+			s.forEmptySlidingWindow()
+			s.addValue(1234, "2025-02-22T12:03:35Z")
+			s.addValue(2345, "2025-02-22T12:04:05Z")
+
+			completed := s.getCompletedWindow("2025-02-22T12:04:15Z")
+			active := s.getActiveWindow("2025-02-22T12:04:05Z")
+			Expect(completed).To(Equal(active))
+		})
+
+		It("returns NO completed window before first step ends", func() {
+			s.forEmptySlidingWindow()
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+
+			Expect(s.getCompletedWindow("2025-02-22T12:04:09Z")).To(BeNil())
+			Expect(s.getCompletedWindow("2025-02-22T12:04:10Z")).NotTo(BeNil())
+		})
+	})
+
+	Context("closed windows", func() {
+		It("notifies every closed window with values once", func() {
+			s.forEmptySlidingWindow()
+			closed := s.collectClosedWindows()
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+
+			s.advance("2025-02-22T12:04:25Z")
+			s.advance("2025-02-22T12:04:25Z")
+			s.advance("2025-02-22T12:04:15Z")
+			s.advance("2025-02-22T13:00:00Z")
+
+			Expect(closed.EndTimes()).To(Equal([]time.Time{
+				clock.ParseTime("2025-02-22T12:04:10Z"),
+				clock.ParseTime("2025-02-22T12:04:20Z"),
+				clock.ParseTime("2025-02-22T12:04:30Z"),
+				clock.ParseTime("2025-02-22T12:04:40Z"),
+				clock.ParseTime("2025-02-22T12:04:50Z"),
+				clock.ParseTime("2025-02-22T12:05:00Z"),
+			}))
+			for _, window := range *closed {
+				Expect(window.Accumulator.values).To(Equal([]float64{1234}))
+			}
+		})
+
+		It("adding value closes windows ending before it", func() {
+			s.forEmptySlidingWindow()
+			closed := s.collectClosedWindows()
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+			s.addValue(2345, "2025-02-22T12:04:15Z")
+			s.addValue(3456, "2025-02-22T12:04:25Z")
+
+			Expect(closed.EndTimes()).To(Equal([]time.Time{
+				clock.ParseTime("2025-02-22T12:04:10Z"),
+				clock.ParseTime("2025-02-22T12:04:20Z"),
+			}))
+			Expect((*closed)[1].Accumulator.values).To(Equal([]float64{1234, 2345}))
+		})
+
+		It("does not notify windows closed before first value", func() {
+			s.forEmptySlidingWindow()
+			closed := s.collectClosedWindows()
+			s.advance("2025-02-22T12:00:00Z")
+			s.advance("2025-02-22T12:04:00Z")
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+
+			Expect(*closed).To(BeEmpty())
+		})
+
+		It("skips empty windows between steps with values", func() {
+			s.forEmptySlidingWindow()
+			closed := s.collectClosedWindows()
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+			s.addValue(2345, "2025-02-22T12:30:05Z")
+
+			s.advance("2025-02-22T13:00:00Z")
+
+			Expect(closed.EndTimes()).To(HaveLen(12))
+			Expect(closed.EndTimes()[5]).To(Equal(clock.ParseTime("2025-02-22T12:05:00Z")))
+			Expect(closed.EndTimes()[6]).To(Equal(clock.ParseTime("2025-02-22T12:30:10Z")))
+			Expect((*closed)[6].Accumulator.values).To(Equal([]float64{2345}))
+		})
+
+		It("recycles the whole ring after idle gap longer than window", func() {
+			s.forEmptySlidingWindow()
+			closed := s.collectClosedWindows()
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+
+			s.advance("2025-03-24T12:04:05Z")
+			s.addValue(2345, "2025-02-22T12:04:15Z")
+
+			Expect(closed.EndTimes()).To(HaveLen(6))
+			Expect(s.getActiveWindow("2025-02-22T12:04:15Z")).To(BeNil())
+			Expect(s.getActiveWindow("2025-03-24T12:04:05Z")).To(BeNil())
+		})
+
+		It("hooks can read active window", func() {
+			s.forEmptySlidingWindow()
+			var active []*metrics.Window[float64, *float64ArrAcc]
+			s.slidingWindow.OnWindowClosed(func(window *metrics.Window[float64, *float64ArrAcc]) {
+				active = append(active, s.slidingWindow.GetActiveWindow(window.EndTime))
+			})
+			s.addValue(1234, "2025-02-22T12:04:05Z")
+			s.advance("2025-02-22T12:04:10Z")
+
+			Expect(active).To(HaveLen(1))
+			Expect(active[0].StartTime).To(Equal(clock.ParseTime("2025-02-22T12:03:20Z")))
+		})
+	})
+
+	Context("driven by clock", func() {
+		It("adds values and closes windows at time of clock", func() {
+			manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+			window := metrics.NewSlidingWindow(newArrAccumulator, time.Minute, 10*time.Second, metrics.WithClock(manual))
+			var closed scrolledWindows
+			window.OnWindowClosed(func(w *metrics.Window[float64, *float64ArrAcc]) {
+				closed = append(closed, w)
+			})
+
+			window.Add(1234)
+			manual.Advance(10 * time.Second)
+			window.Add(2345)
+			manual.Advance(10 * time.Second)
+			window.Tick()
+
+			Expect(window.ActiveWindow().Accumulator.values).To(Equal([]float64{1234, 2345}))
+			Expect(closed.EndTimes()).To(Equal([]time.Time{
+				clock.ParseTime("2025-02-22T12:04:10Z"),
+				clock.ParseTime("2025-02-22T12:04:20Z"),
+			}))
+		})
+	})
+
+	Context("size not multiple of grace period", func() {
+		It("covers size rounded up to whole grace periods", func() {
+			window := metrics.NewSlidingWindow(newArrAccumulator, 25*time.Second, 10*time.Second)
+			window.AddValue(clock.ParseTime("2025-02-22T12:04:00Z"), 1)
+			window.AddValue(clock.ParseTime("2025-02-22T12:04:10Z"), 2)
+			window.AddValue(clock.ParseTime("2025-02-22T12:04:20Z"), 3)
+
+			completed := window.GetCompletedWindow(clock.ParseTime("2025-02-22T12:04:30Z"))
+			Expect(completed.StartTime).To(Equal(clock.ParseTime("2025-02-22T12:04:00Z")))
+			Expect(completed.EndTime).To(Equal(clock.ParseTime("2025-02-22T12:04:30Z")))
+			Expect(completed.Accumulator.values).To(Equal([]float64{1, 2, 3}))
+
+			next := window.GetCompletedWindow(clock.ParseTime("2025-02-22T12:04:40Z"))
+			Expect(next.StartTime).To(Equal(clock.ParseTime("2025-02-22T12:04:10Z")))
+			Expect(next.Accumulator.values).To(Equal([]float64{2, 3}))
+		})
+	})
+
+	Context("concurrent use", func() {
+		It("keeps values added from multiple goroutines", func() {
+			s.forEmptyHistogramSlidingWindow()
+			now := clock.ParseTime("2025-02-22T12:04:00Z")
+
+			var wg sync.WaitGroup
+			for writer := range 8 {
+				wg.Go(func() {
+					for i := range 600 {
+						s.histogramSlidingWindow.AddValue(now.Add(time.Duration(i)*100*time.Millisecond), float64(writer+1))
+						s.histogramSlidingWindow.GetActiveWindow(now)
+					}
+				})
+			}
+			wg.Wait()
+
+			window := s.histogramSlidingWindow.GetCompletedWindow(now.Add(time.Minute))
+			Expect(window).NotTo(BeNil())
+			_, count := window.Accumulator.ComputePercentile(0.5)
+			Expect(count).To(BeNumerically("==", 8*600))
+		})
+	})
 })
 
 type sutslidingwindow struct {
-	slidingWindow *metrics.SlidingWindow[float64, *float64ArrAcc]
+	slidingWindow          *metrics.SlidingWindow[float64, *float64ArrAcc]
+	histogramSlidingWindow *metrics.SlidingWindow[float64, *metrics.LatencyHistogram]
+}
+
+func (s *sutslidingwindow) forEmptyHistogramSlidingWindow() {
+	s.histogramSlidingWindow = metrics.NewSlidingWindow(
+		newHistogramAccumulator,
+		1*time.Minute,
+		10*time.Second,
+	)
+}
+
+func newHistogramAccumulator() *metrics.LatencyHistogram {
+	return metrics.NewLatencyHistogram(nil)
+}
+
+func (s *sutslidingwindow) addHistogramValue(latency float64, nowString string) {
+	now := clock.ParseTime(nowString)
+	s.histogramSlidingWindow.AddValue(now, latency)
 }
 
 func (s *sutslidingwindow) forEmptySlidingWindow() {
@@ -125,7 +417,36 @@ func parseTimePtr(nowString string) *time.Time {
 
 func (s *sutslidingwindow) getActiveWindow(nowString string) *metrics.Window[float64, *float64ArrAcc] {
 	now := clock.ParseTime(nowString)
-	return s.slidingWindow.GetActiveWindow(now)
+	window := s.slidingWindow.GetActiveWindow(now)
+	Expect(s.slidingWindow.HasValues(now)).To(Equal(window != nil))
+	return window
+}
+
+func (s *sutslidingwindow) getCompletedWindow(nowString string) *metrics.Window[float64, *float64ArrAcc] {
+	now := clock.ParseTime(nowString)
+	return s.slidingWindow.GetCompletedWindow(now)
+}
+
+func (s *sutslidingwindow) scrollBySecond(nowStr string, count int) []*metrics.Window[float64, *float64ArrAcc] {
+	now := clock.ParseTime(nowStr)
+	var windows []*metrics.Window[float64, *float64ArrAcc]
+	for i := range count {
+		windows = append(windows, s.slidingWindow.GetActiveWindow(now.Add(time.Duration(i)*time.Second)))
+	}
+	return windows
+}
+
+func (s *sutslidingwindow) advance(nowString string) {
+	now := clock.ParseTime(nowString)
+	s.slidingWindow.Advance(now)
+}
+
+func (s *sutslidingwindow) collectClosedWindows() *scrolledWindows {
+	var closed scrolledWindows
+	s.slidingWindow.OnWindowClosed(func(window *metrics.Window[float64, *float64ArrAcc]) {
+		closed = append(closed, window)
+	})
+	return &closed
 }
 
 func (s *sutslidingwindow) addValue(latency float64, nowString string) {
@@ -168,6 +489,14 @@ func (s *scrolledWindows) StartTimes() []*time.Time {
 	return startTimes
 }
 
+func (s *scrolledWindows) EndTimes() []time.Time {
+	var endTimes []time.Time
+	for _, window := range *s {
+		endTimes = append(endTimes, window.EndTime)
+	}
+	return endTimes
+}
+
 type float64ArrAcc struct {
 	values []float64
 }
@@ -179,3 +508,40 @@ func newArrAccumulator() *float64ArrAcc {
 func (a *float64ArrAcc) Add(value float64) {
 	a.values = append(a.values, value)
 }
+
+// BenchmarkSlidingWindow adds one latency per second into windows sliding
+// by one minute and reads active window once per step.
+func BenchmarkSlidingWindow(b *testing.B) {
+	sizes := []struct {
+		name string
+		size time.Duration
+	}{
+		{"1h", time.Hour},
+		{"1d", 24 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+	}
+	start := clock.ParseTime("2025-02-22T12:04:05Z")
+
+	for _, size := range sizes {
+		b.Run("add/"+size.name, func(b *testing.B) {
+			window := metrics.NewSlidingWindow(newHistogramAccumulator, size.size, time.Minute)
+			now := start
+			for b.Loop() {
+				now = now.Add(time.Second)
+				window.AddValue(now, 17)
+			}
+		})
+
+		b.Run("read/"+size.name, func(b *testing.B) {
+			window := metrics.NewSlidingWindow(newHistogramAccumulator, size.size, time.Minute)
+			now := start
+			for offset := time.Duration(0); offset < size.size; offset += time.Minute {
+				now = start.Add(offset)
+				window.AddValue(now, 17)
+			}
+			for b.Loop() {
+				window.GetActiveWindow(now)
+			}
+		})
+	}
+}
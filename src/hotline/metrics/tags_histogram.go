@@ -1,5 +1,10 @@
 package metrics
 
+import (
+	"fmt"
+	"slices"
+)
+
 type TagHistogram[T comparable] struct {
 	buckets *bucketedCounters
 	layout  *tagsLayout[T]
@@ -39,6 +44,35 @@ func (h *TagHistogram[T]) ComputePercentile(tag T) (*float64, int64) {
 	return &percentile, sum
 }
 
+// Merge adds all tags of other histogram. Histograms must share tags.
+func (h *TagHistogram[T]) Merge(other *TagHistogram[T]) error {
+	if err := h.compatible(other); err != nil {
+		return err
+	}
+	h.buckets.Merge(other.buckets)
+	return nil
+}
+
+// Subtract removes tags of other histogram, which must have been merged
+// into h before. Histograms must share tags.
+func (h *TagHistogram[T]) Subtract(other *TagHistogram[T]) error {
+	if err := h.compatible(other); err != nil {
+		return err
+	}
+	if !h.buckets.CanSubtract(other.buckets) {
+		return ErrNegativeCount
+	}
+	h.buckets.Subtract(other.buckets)
+	return nil
+}
+
+func (h *TagHistogram[T]) compatible(other *TagHistogram[T]) error {
+	if !slices.Equal(h.layout.tags, other.layout.tags) {
+		return fmt.Errorf("%w: tags %v and %v differ", ErrIncompatibleHistograms, h.layout.tags, other.layout.tags)
+	}
+	return nil
+}
+
 type tagsLayout[T comparable] struct {
 	tags              []T
 	toIndex           map[T]*bucketIndex
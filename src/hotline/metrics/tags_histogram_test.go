@@ -51,6 +51,71 @@ var _ = Describe("Tags Histogram", func() {
 	})
 })
 
+var _ = Describe("Tags Histogram composition", func() {
+	s := suttagshistogram{}
+
+	It("merged histogram counts tags of both", func() {
+		s.forHistogram("success", "failure")
+		s.Add("success")
+		other := metrics.NewTagsHistogram([]string{"success", "failure"})
+		other.Add("failure")
+		other.Add("failure")
+		other.Add("success")
+
+		Expect(s.histogram.Merge(other)).To(Succeed())
+
+		Expect(s.histogram.Total()).To(BeNumerically("==", 4))
+		Expect(*s.getPercentile("success")).To(BeNumerically("==", 50))
+		Expect(other.Total()).To(BeNumerically("==", 3))
+	})
+
+	It("subtracting merged histogram restores shares", func() {
+		s.forHistogram("success", "failure")
+		s.Add("success")
+		s.Add("failure")
+		other := metrics.NewTagsHistogram([]string{"success", "failure"})
+		other.Add("failure")
+		other.Add("failure")
+
+		Expect(s.histogram.Merge(other)).To(Succeed())
+		Expect(s.histogram.Subtract(other)).To(Succeed())
+
+		Expect(*s.getPercentile("success")).To(BeNumerically("==", 50))
+		Expect(*s.getPercentile("failure")).To(BeNumerically("==", 50))
+	})
+
+	It("forgets tags subtracted entirely", func() {
+		s.forHistogram("success", "failure")
+		s.Add("success")
+		s.Add("failure")
+		other := metrics.NewTagsHistogram([]string{"success", "failure"})
+		other.Add("failure")
+
+		Expect(s.histogram.Subtract(other)).To(Succeed())
+
+		Expect(s.getPercentile("failure")).To(BeNil())
+		Expect(*s.getPercentile("success")).To(BeNumerically("==", 100))
+	})
+
+	It("refuses to subtract tags not in histogram", func() {
+		s.forHistogram("success", "failure")
+		s.Add("success")
+		other := metrics.NewTagsHistogram([]string{"success", "failure"})
+		other.Add("failure")
+
+		Expect(s.histogram.Subtract(other)).To(MatchError(metrics.ErrNegativeCount))
+		Expect(s.histogram.Total()).To(BeNumerically("==", 1))
+	})
+
+	It("refuses to compose histograms with different tags", func() {
+		s.forHistogram("success", "failure")
+		other := metrics.NewTagsHistogram([]string{"failure", "success"})
+
+		Expect(s.histogram.Merge(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+		Expect(s.histogram.Subtract(other)).To(MatchError(metrics.ErrIncompatibleHistograms))
+	})
+})
+
 type suttagshistogram struct {
 	histogram *metrics.TagHistogram[string]
 }
@@ -0,0 +1,214 @@
+package tdigest
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+var ErrInvalidHalfLife = errors.New("half-life must be positive")
+
+// maxDecayExponent bounds forward decay weights to 2^512, far from float64
+// overflow even when summed.
+const maxDecayExponent = 512
+
+type DecayedCentroid struct {
+	Mean   float64
+	Weight float64
+}
+
+// DecayingTDigest is a t-digest whose weights decay exponentially with
+// configured half-life, so quantiles describe recent values without hard
+// window boundaries.
+//
+// It uses forward decay: value added at time t weighs 2^((t-landmark)/halfLife),
+// so centroids are never touched when adding values and quantiles, being
+// relative, do not depend on the landmark. Landmark moves forward,
+// rescaling all weights, before weights grow too big.
+type DecayingTDigest struct {
+	centroids          []DecayedCentroid
+	spare              []DecayedCentroid
+	totalWeight        float64
+	quantileMaxWeights MaxWeightFunc
+	capacity           int
+	bufferSize         int
+	halfLife           time.Duration
+	landmark           time.Time
+	latest             time.Time
+
+	unprocessed []DecayedCentroid
+}
+
+func NewDecayingTDigest(capacity int, bufferSize int, halfLife time.Duration, opts ...Option) (*DecayingTDigest, error) {
+	if halfLife <= 0 {
+		return nil, fmt.Errorf("%w, got %s", ErrInvalidHalfLife, halfLife)
+	}
+	o := options{scale: ScaleWeight}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	maxWeights, err := o.scale.maxWeightFunc(capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &DecayingTDigest{
+		centroids:          make([]DecayedCentroid, 0, capacity),
+		spare:              make([]DecayedCentroid, 0, capacity),
+		quantileMaxWeights: maxWeights,
+		capacity:           capacity,
+		bufferSize:         bufferSize,
+		halfLife:           halfLife,
+		unprocessed:        make([]DecayedCentroid, 0, bufferSize),
+	}, nil
+}
+
+// Add adds value observed at now. Values observed earlier than the latest
+// one are accepted, they are just weighted down accordingly.
+func (d *DecayingTDigest) Add(now time.Time, value float64) {
+	if d.landmark.IsZero() {
+		d.landmark = now
+	}
+	if now.After(d.latest) {
+		d.latest = now
+	}
+	if d.decayExponent(now) > maxDecayExponent {
+		d.moveLandmark(now)
+	}
+
+	d.unprocessed = append(d.unprocessed, DecayedCentroid{
+		Mean:   value,
+		Weight: math.Exp2(d.decayExponent(now)),
+	})
+	if len(d.unprocessed) >= d.bufferSize {
+		d.processBuffer()
+	}
+}
+
+func (d *DecayingTDigest) decayExponent(now time.Time) float64 {
+	return float64(now.Sub(d.landmark)) / float64(d.halfLife)
+}
+
+func (d *DecayingTDigest) moveLandmark(now time.Time) {
+	d.processBuffer()
+	factor := math.Exp2(-d.decayExponent(now))
+	for index := range d.centroids {
+		d.centroids[index].Weight *= factor
+	}
+	d.totalWeight *= factor
+	d.landmark = now
+}
+
+// Weight is the decayed number of values as of now.
+func (d *DecayingTDigest) Weight(now time.Time) float64 {
+	d.processBuffer()
+	if d.totalWeight == 0 {
+		return 0
+	}
+	return d.totalWeight * math.Exp2(-d.decayExponent(now))
+}
+
+func (d *DecayingTDigest) processBuffer() {
+	if len(d.unprocessed) == 0 {
+		return
+	}
+	slices.SortFunc(d.unprocessed, func(a, b DecayedCentroid) int {
+		return cmp.Compare(a.Mean, b.Mean)
+	})
+
+	totalWeight := d.totalWeight
+	for _, centroid := range d.unprocessed {
+		totalWeight += centroid.Weight
+	}
+	// Max weight functions bound number of values, so weights are
+	// expressed in units of a value observed at the latest time.
+	unit := math.Exp2(d.decayExponent(d.latest))
+	values := uint64(math.Ceil(totalWeight / unit))
+
+	compressed := d.spare[:0]
+	centroidsIndex, bufferIndex := 0, 0
+	next := func() (DecayedCentroid, bool) {
+		hasCentroid := centroidsIndex < len(d.centroids)
+		hasBuffered := bufferIndex < len(d.unprocessed)
+		if hasCentroid && (!hasBuffered || d.centroids[centroidsIndex].Mean <= d.unprocessed[bufferIndex].Mean) {
+			centroidsIndex++
+			return d.centroids[centroidsIndex-1], true
+		}
+		if hasBuffered {
+			bufferIndex++
+			return d.unprocessed[bufferIndex-1], true
+		}
+		return DecayedCentroid{}, false
+	}
+
+	current, _ := next()
+	cumulativeWeight := 0.0
+	for candidate, found := next(); found; candidate, found = next() {
+		q0 := cumulativeWeight / totalWeight
+		q2 := (cumulativeWeight + current.Weight + candidate.Weight) / totalWeight
+		weightThreshold := d.quantileMaxWeights(q0, q2, values) * unit
+
+		if current.Mean == candidate.Mean || current.Weight+candidate.Weight <= weightThreshold {
+			merged := current.Weight + candidate.Weight
+			current.Mean += (candidate.Mean - current.Mean) * candidate.Weight / merged
+			current.Weight = merged
+			continue
+		}
+
+		compressed = append(compressed, current)
+		cumulativeWeight += current.Weight
+		current = candidate
+	}
+	compressed = append(compressed, current)
+
+	d.spare = d.centroids[:0]
+	d.centroids = compressed
+	d.totalWeight = totalWeight
+	d.unprocessed = d.unprocessed[:0]
+}
+
+func (d *DecayingTDigest) ToCentroids() []DecayedCentroid {
+	d.processBuffer()
+	return slices.Clone(d.centroids)
+}
+
+// Quantile interpolates between centers of neighbouring centroids. Recent
+// values dominate, values older than a few half-lives barely count.
+func (d *DecayingTDigest) Quantile(percentile float64) float64 {
+	d.processBuffer()
+
+	if percentile < 0 || percentile > 1 {
+		return math.NaN()
+	}
+	size := len(d.centroids)
+	if size == 0 {
+		return 0
+	}
+
+	index := percentile * d.totalWeight
+	first := d.centroids[0]
+	last := d.centroids[size-1]
+	if index <= first.Weight/2 {
+		return first.Mean
+	}
+	if index >= d.totalWeight-last.Weight/2 {
+		return last.Mean
+	}
+
+	// index is between centers of the first and the last centroid, the last
+	// pair interpolates whatever is left after rounding
+	weightSoFar := first.Weight / 2
+	i := 0
+	for ; i < size-2; i++ {
+		betweenCenters := (d.centroids[i].Weight + d.centroids[i+1].Weight) / 2
+		if weightSoFar+betweenCenters > index {
+			break
+		}
+		weightSoFar += betweenCenters
+	}
+	left, right := d.centroids[i], d.centroids[i+1]
+	betweenCenters := (left.Weight + right.Weight) / 2
+	return weightedAverage(left.Mean, weightSoFar+betweenCenters-index, right.Mean, index-weightSoFar)
+}
@@ -0,0 +1,126 @@
+package tdigest_test
+
+import (
+	"hotline/metrics/tdigest"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decaying TDigest", func() {
+	sut := decayingSut{}
+
+	It("fails to create digest without positive half-life", func() {
+		_, err := tdigest.NewDecayingTDigest(100, 500, 0)
+		Expect(err).To(MatchError(tdigest.ErrInvalidHalfLife))
+	})
+
+	It("fails to create digest with unknown scale", func() {
+		_, err := tdigest.NewDecayingTDigest(100, 500, time.Minute, tdigest.WithScale(tdigest.Scale(99)))
+		Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+	})
+
+	It("computes 0 for an empty digest", func() {
+		sut.forDigest(time.Minute)
+
+		Expect(sut.digest.Quantile(0.99)).To(BeZero())
+		Expect(sut.digest.Weight(sut.now)).To(BeZero())
+	})
+
+	It("returns NaN for quantiles out of range [0.0, 1.0]", func() {
+		sut.forDigest(time.Minute)
+		sut.addUniform(100, 0, 10)
+
+		Expect(math.IsNaN(sut.digest.Quantile(-0.01))).To(BeTrue())
+		Expect(math.IsNaN(sut.digest.Quantile(1.01))).To(BeTrue())
+	})
+
+	It("returns means of outer centroids for extreme quantiles", func() {
+		sut.forDigest(time.Minute)
+		sut.digest.Add(sut.now, 1)
+		sut.digest.Add(sut.now, 3)
+
+		Expect(sut.digest.Quantile(0)).To(Equal(1.0))
+		Expect(sut.digest.Quantile(0.5)).To(Equal(2.0))
+		Expect(sut.digest.Quantile(1)).To(Equal(3.0))
+	})
+
+	It("behaves as plain digest for values observed at the same time", func() {
+		sut.forDigest(time.Minute)
+		sut.addUniform(100_000, 0.5, 10.5)
+
+		Expect(sut.digest.Quantile(0.5)).To(BeNumerically("~", 5.5, 0.1))
+		Expect(sut.digest.Quantile(0.99)).To(BeNumerically("~", 10.4, 0.05))
+		Expect(len(sut.digest.ToCentroids())).To(BeNumerically("<=", 100))
+	})
+
+	It("halves weight every half-life", func() {
+		sut.forDigest(time.Minute)
+		sut.addUniform(100, 0, 10)
+
+		Expect(sut.digest.Weight(sut.now)).To(BeNumerically("~", 100, 1e-9))
+		Expect(sut.digest.Weight(sut.now.Add(time.Minute))).To(BeNumerically("~", 50, 1e-9))
+		Expect(sut.digest.Weight(sut.now.Add(3 * time.Minute))).To(BeNumerically("~", 12.5, 1e-9))
+	})
+
+	It("reports recent quantiles after latencies shift", func() {
+		sut.forDigest(time.Minute)
+		sut.addUniform(10_000, 0, 1)
+		sut.advance(10 * time.Minute)
+		sut.addUniform(10_000, 10, 11)
+
+		Expect(sut.digest.Quantile(0.01)).To(BeNumerically(">", 10))
+		Expect(sut.digest.Quantile(0.5)).To(BeNumerically("~", 10.5, 0.05))
+	})
+
+	It("mixes values observed within a half-life", func() {
+		sut.forDigest(time.Hour)
+		sut.addUniform(10_000, 0, 1)
+		sut.advance(time.Minute)
+		sut.addUniform(10_000, 10, 11)
+
+		Expect(sut.digest.Quantile(0.25)).To(BeNumerically("<", 1))
+		Expect(sut.digest.Quantile(0.75)).To(BeNumerically(">", 10))
+	})
+
+	It("keeps weights finite over thousands of half-lives", func() {
+		sut.forDigest(time.Second)
+		for range 2000 {
+			sut.addUniform(10, 1, 2)
+			sut.advance(time.Second)
+		}
+
+		Expect(sut.digest.Weight(sut.now)).To(BeNumerically("~", 10, 0.1))
+		Expect(sut.digest.Quantile(0.5)).To(BeNumerically("~", 1.5, 0.5))
+		for _, centroid := range sut.digest.ToCentroids() {
+			Expect(math.IsInf(centroid.Weight, 0) || math.IsNaN(centroid.Weight)).To(BeFalse())
+		}
+	})
+})
+
+type decayingSut struct {
+	digest     *tdigest.DecayingTDigest
+	now        time.Time
+	randomizer *rand.Rand
+}
+
+func (s *decayingSut) forDigest(halfLife time.Duration) {
+	digest, err := tdigest.NewDecayingTDigest(100, 500, halfLife)
+	Expect(err).NotTo(HaveOccurred())
+	s.digest = digest
+	s.now = time.Date(2025, 2, 22, 12, 0, 0, 0, time.UTC)
+	s.randomizer = rand.New(rand.NewPCG(190, 89992))
+}
+
+func (s *decayingSut) addUniform(count int, from float64, to float64) {
+	for range count {
+		s.digest.Add(s.now, from+(to-from)*s.randomizer.Float64())
+	}
+}
+
+func (s *decayingSut) advance(duration time.Duration) {
+	s.now = s.now.Add(duration)
+}
@@ -1,7 +1,9 @@
 package tdigest
 
 import (
+	"cmp"
 	"math"
+	"slices"
 	"sort"
 )
 
@@ -84,6 +86,42 @@ func (c *Centroids) CentroidAt(index int) Centroid {
 	}
 }
 
+// Union returns new centroids containing centroids of both c and other,
+// sorted by mean. Centroids are not compressed.
+func (c *Centroids) Union(other *Centroids) *Centroids {
+	union := NewCentroids(c.Size() + other.Size())
+	i, j := 0, 0
+	for i < c.Size() && j < other.Size() {
+		if c.mean[i] <= other.mean[j] {
+			union.appendCentroid(c.mean[i], c.weight[i])
+			i++
+		} else {
+			union.appendCentroid(other.mean[j], other.weight[j])
+			j++
+		}
+	}
+	for ; i < c.Size(); i++ {
+		union.appendCentroid(c.mean[i], c.weight[i])
+	}
+	for ; j < other.Size(); j++ {
+		union.appendCentroid(other.mean[j], other.weight[j])
+	}
+	return union
+}
+
+func (c *Centroids) reset() {
+	c.mean = c.mean[:0]
+	c.weight = c.weight[:0]
+	c.totalWeight = 0
+}
+
+// appendCentroid adds centroid to the end, caller guarantees mean order.
+func (c *Centroids) appendCentroid(mean float64, weight uint64) {
+	c.mean = append(c.mean, mean)
+	c.weight = append(c.weight, weight)
+	c.totalWeight += weight
+}
+
 func (c *Centroids) FittingCumulativeWeightCentroid(sum uint64) (uint64, int, bool) {
 	if len(c.weight) == 0 {
 		return 0, 0, false
@@ -114,47 +152,104 @@ func NewCentroids(capacity int) *Centroids {
 type MaxWeightFunc func(quantile1, quantile2 float64, totalWeight uint64) float64
 type TDigest struct {
 	centroids          *Centroids
+	spare              *Centroids
 	quantileMaxWeights MaxWeightFunc
+	scale              Scale
 	capacity           int
 	bufferSize         int
+	min                float64
+	max                float64
+	sum                float64
 
 	unprocessed CentroidBuffer
 }
 
+func (d *TDigest) Capacity() int {
+	return d.capacity
+}
+
+func (d *TDigest) BufferSize() int {
+	return d.bufferSize
+}
+
+func (d *TDigest) Scale() Scale {
+	return d.scale
+}
+
+// Min is the smallest value added to the digest, 0 for an empty digest.
+func (d *TDigest) Min() float64 {
+	if d.isEmpty() {
+		return 0
+	}
+	return d.min
+}
+
+// Max is the biggest value added to the digest, 0 for an empty digest.
+func (d *TDigest) Max() float64 {
+	if d.isEmpty() {
+		return 0
+	}
+	return d.max
+}
+
+// Count is the total weight of all values added to the digest.
+func (d *TDigest) Count() uint64 {
+	return d.centroids.TotalWeight() + d.unprocessed.TotalWeight()
+}
+
+// Sum is the weighted sum of all values added to the digest. Digest decoded
+// from encoding without sum estimates it from centroids, which is exact up
+// to rounding of means.
+func (d *TDigest) Sum() float64 {
+	return d.sum
+}
+
+func (d *TDigest) isEmpty() bool {
+	return d.centroids.Size() == 0 && len(d.unprocessed) == 0
+}
+
+func (d *TDigest) updateExtremes(minimum float64, maximum float64) {
+	d.min = math.Min(d.min, minimum)
+	d.max = math.Max(d.max, maximum)
+}
+
 func (d *TDigest) AddToBuffer(mean float64, weight uint64) {
-	d.unprocessed = append(d.unprocessed, Centroid{
+	d.updateExtremes(mean, mean)
+	d.sum += mean * float64(weight)
+	d.bufferCentroid(Centroid{
 		Mean:   mean,
 		Weight: weight,
 	})
+}
 
+// bufferCentroid appends centroid to the buffer and processes the buffer
+// once it is full.
+func (d *TDigest) bufferCentroid(centroid Centroid) {
+	d.unprocessed = append(d.unprocessed, centroid)
 	if len(d.unprocessed) >= d.bufferSize {
 		d.processBuffer()
 	}
 }
 
+// processBuffer sorts the buffer in place and merges it with already sorted
+// centroids into the spare centroids, compressing greedily on the way.
+// Centroids are then swapped with the spare ones, so in steady state the
+// processing reuses the same arrays and does not allocate.
 func (d *TDigest) processBuffer() {
 	if len(d.unprocessed) == 0 {
 		return
 	}
+	slices.SortFunc(d.unprocessed, compareMeans)
 
-	d.centroids = d.greedyCompress(append(d.centroids.ToList(), d.unprocessed...))
-	d.unprocessed = d.unprocessed[:0]
-}
+	totalWeight := d.centroids.TotalWeight() + d.unprocessed.TotalWeight()
+	compressed := d.spare
+	compressed.reset()
 
-func (d *TDigest) greedyCompress(values CentroidBuffer) *Centroids {
-	if len(values) == 0 {
-		return NewCentroids(d.capacity)
-	}
-	sort.Slice(values, func(i, j int) bool {
-		return values[i].Mean < values[j].Mean
-	})
-
-	totalWeight := values.TotalWeight()
-	compressed := NewCentroids(d.capacity)
-	current := values[0]
+	sorted := sortedMerge{centroids: d.centroids, buffer: d.unprocessed}
+	current, _ := sorted.next()
 	cumulativeWeight := uint64(0)
 
-	for _, candidate := range values[1:] {
+	for candidate, found := sorted.next(); found; candidate, found = sorted.next() {
 		q0 := float64(cumulativeWeight) / float64(totalWeight)
 		q2 := float64(cumulativeWeight+current.Weight+candidate.Weight) / float64(totalWeight)
 		weightThreshold := d.quantileMaxWeights(q0, q2, totalWeight)
@@ -164,13 +259,82 @@ func (d *TDigest) greedyCompress(values CentroidBuffer) *Centroids {
 			continue
 		}
 
-		compressed.AddCentroid(current.Mean, current.Weight)
+		compressed.appendCentroid(current.Mean, current.Weight)
 		cumulativeWeight += current.Weight
 		current = candidate
 	}
+	compressed.appendCentroid(current.Mean, current.Weight)
+
+	d.spare = d.centroids
+	d.centroids = compressed
+	d.unprocessed = d.unprocessed[:0]
+}
 
-	compressed.AddCentroid(current.Mean, current.Weight)
-	return compressed
+func compareMeans(a, b Centroid) int {
+	return cmp.Compare(a.Mean, b.Mean)
+}
+
+// sortedMerge iterates centroids and sorted buffer together in mean order.
+type sortedMerge struct {
+	centroids      *Centroids
+	buffer         CentroidBuffer
+	centroidsIndex int
+	bufferIndex    int
+}
+
+func (m *sortedMerge) next() (Centroid, bool) {
+	hasCentroid := m.centroidsIndex < m.centroids.Size()
+	hasBuffered := m.bufferIndex < len(m.buffer)
+
+	if hasCentroid && (!hasBuffered || m.centroids.mean[m.centroidsIndex] <= m.buffer[m.bufferIndex].Mean) {
+		centroid := m.centroids.CentroidAt(m.centroidsIndex)
+		m.centroidsIndex++
+		return centroid, true
+	}
+	if hasBuffered {
+		centroid := m.buffer[m.bufferIndex]
+		m.bufferIndex++
+		return centroid, true
+	}
+	return Centroid{}, false
+}
+
+// Merge folds centroids and unprocessed buffer of other digest into d.
+// other is left untouched.
+func (d *TDigest) Merge(other *TDigest) {
+	d.MergeAll(other)
+}
+
+// MergeAll folds centroids and unprocessed buffers of all others into d.
+// Others are left untouched. Centroids are added through the buffer of d,
+// processed whenever the buffer fills up, so the buffer never outgrows its
+// size. Others may use any scale and capacity, merged centroids are
+// compressed by the scale and capacity of d.
+func (d *TDigest) MergeAll(others ...*TDigest) {
+	for _, other := range others {
+		if other == nil || other.isEmpty() {
+			continue
+		}
+		centroids, unprocessed := other.centroids, other.unprocessed
+		if other == d {
+			// processing the buffer swaps centroids of d, merge a copy
+			centroids = &Centroids{
+				mean:        slices.Clone(d.centroids.mean),
+				weight:      slices.Clone(d.centroids.weight),
+				totalWeight: d.centroids.totalWeight,
+			}
+			unprocessed = slices.Clone(d.unprocessed)
+		}
+		d.sum += other.sum
+		d.updateExtremes(other.min, other.max)
+		for index := range centroids.Size() {
+			d.bufferCentroid(centroids.CentroidAt(index))
+		}
+		for _, centroid := range unprocessed {
+			d.bufferCentroid(centroid)
+		}
+	}
+	d.processBuffer()
 }
 
 func (d *TDigest) ToCentroids() []Centroid {
@@ -178,48 +342,230 @@ func (d *TDigest) ToCentroids() []Centroid {
 	return d.centroids.ToList()
 }
 
+// Quantile interpolates between centers of neighbouring centroids, as the
+// reference t-digest does. Centroids of weight 1 are exact values and are
+// not spread. Outer halves of the first and the last centroids are spread
+// up to exact min and max, so tail quantiles are not cut at centroid means.
 func (d *TDigest) Quantile(percentile float64) float64 {
 	d.processBuffer()
 
 	if percentile < 0 || percentile > 1 {
 		return math.NaN()
 	}
-	if d.centroids.Size() == 0 {
+	size := d.centroids.Size()
+	if size == 0 {
 		return 0
 	}
-	if d.centroids.Size() == 1 {
-		return d.centroids.CentroidAt(0).Mean
+
+	mean := d.centroids.mean
+	weight := d.centroids.weight
+	totalWeight := float64(d.centroids.TotalWeight())
+	index := percentile * totalWeight
+
+	if index < 1 {
+		return d.min
+	}
+	if index > totalWeight-1 {
+		return d.max
+	}
+
+	firstWeight := float64(weight[0])
+	if firstWeight > 2 && index < firstWeight/2 {
+		return d.min + (index-1)/(firstWeight/2-1)*(mean[0]-d.min)
+	}
+	lastWeight := float64(weight[size-1])
+	if lastWeight > 2 && totalWeight-index <= lastWeight/2 {
+		return d.max - (totalWeight-index-1)/(lastWeight/2-1)*(d.max-mean[size-1])
+	}
+
+	weightSoFar := firstWeight / 2
+	for i := range size - 1 {
+		leftWeight := float64(weight[i])
+		rightWeight := float64(weight[i+1])
+		betweenCenters := (leftWeight + rightWeight) / 2
+		if weightSoFar+betweenCenters <= index {
+			weightSoFar += betweenCenters
+			continue
+		}
+
+		leftUnit := 0.0
+		if leftWeight == 1 {
+			if index-weightSoFar < 0.5 {
+				return mean[i]
+			}
+			leftUnit = 0.5
+		}
+		rightUnit := 0.0
+		if rightWeight == 1 {
+			if weightSoFar+betweenCenters-index <= 0.5 {
+				return mean[i+1]
+			}
+			rightUnit = 0.5
+		}
+		toLeft := index - weightSoFar - leftUnit
+		toRight := weightSoFar + betweenCenters - index - rightUnit
+		return weightedAverage(mean[i], toRight, mean[i+1], toLeft)
+	}
+	return d.max
+}
+
+// CDF estimates the fraction of values below x, interpolating over
+// centroids the same way Quantile does. Values equal to x are counted by
+// half, as in the reference t-digest. NaN for an empty digest.
+func (d *TDigest) CDF(x float64) float64 {
+	d.processBuffer()
+
+	size := d.centroids.Size()
+	if size == 0 || math.IsNaN(x) {
+		return math.NaN()
+	}
+	if x < d.min {
+		return 0
+	}
+	if x > d.max {
+		return 1
+	}
+
+	mean := d.centroids.mean
+	weight := d.centroids.weight
+	totalWeight := float64(d.centroids.TotalWeight())
+
+	if size == 1 {
+		if d.max == d.min {
+			return 0.5
+		}
+		return (x - d.min) / (d.max - d.min)
+	}
+
+	firstWeight := float64(weight[0])
+	if x < mean[0] {
+		if x == d.min {
+			return 0.5 / totalWeight
+		}
+		return (1 + (x-d.min)/(mean[0]-d.min)*(firstWeight/2-1)) / totalWeight
+	}
+	lastWeight := float64(weight[size-1])
+	if x > mean[size-1] {
+		if x == d.max {
+			return 1 - 0.5/totalWeight
+		}
+		return 1 - (1+(d.max-x)/(d.max-mean[size-1])*(lastWeight/2-1))/totalWeight
 	}
-	percentileCumulativeWeight := uint64(math.Floor(float64(d.centroids.TotalWeight()) * percentile))
 
-	fittingCumulativeWeight, centroidIndex, _ := d.centroids.FittingCumulativeWeightCentroid(percentileCumulativeWeight)
-	fittingCentroid := d.centroids.CentroidAt(centroidIndex)
+	weightSoFar := 0.0
+	for i := 0; i < size-1; i++ {
+		if mean[i] == x {
+			sameWeight := 0.0
+			for ; i < size && mean[i] == x; i++ {
+				sameWeight += float64(weight[i])
+			}
+			return (weightSoFar + sameWeight/2) / totalWeight
+		}
 
-	hasPreviousCentroid := centroidIndex > 0
+		leftWeight := float64(weight[i])
+		rightWeight := float64(weight[i+1])
+		if x > mean[i] && x < mean[i+1] {
+			leftExcluded := 0.0
+			rightExcluded := 0.0
+			if leftWeight == 1 {
+				if rightWeight == 1 {
+					return (weightSoFar + 1) / totalWeight
+				}
+				leftExcluded = 0.5
+			} else if rightWeight == 1 {
+				rightExcluded = 0.5
+			}
+			betweenCenters := (leftWeight + rightWeight) / 2
+			base := weightSoFar + leftWeight/2 + leftExcluded
+			return (base + (betweenCenters-leftExcluded-rightExcluded)*(x-mean[i])/(mean[i+1]-mean[i])) / totalWeight
+		}
+		weightSoFar += leftWeight
+	}
+	return (weightSoFar + lastWeight/2) / totalWeight
+}
 
-	if !hasPreviousCentroid {
-		return d.centroids.CentroidAt(0).Mean
+// Rank estimates the number of values less or equal to x, 0 for an empty
+// digest. Unlike CDF, values equal to x are counted fully, so good events
+// at threshold are not underestimated.
+func (d *TDigest) Rank(x float64) float64 {
+	cdf := d.CDF(x)
+	if math.IsNaN(cdf) {
+		return 0
 	}
+	totalWeight := float64(d.centroids.TotalWeight())
+	return math.Min(totalWeight, cdf*totalWeight+d.weightAt(x)/2)
+}
 
-	previousCentroid := d.centroids.CentroidAt(centroidIndex - 1)
-	previousCumulativeWeight := fittingCumulativeWeight - fittingCentroid.Weight
+// weightAt is the weight CDF counts by half at x, of centroids with mean x
+// or of single min or max value outside of centroids.
+func (d *TDigest) weightAt(x float64) float64 {
+	size := d.centroids.Size()
+	if size == 1 && d.min == d.max {
+		if x == d.min {
+			return float64(d.centroids.weight[0])
+		}
+		return 0
+	}
 
-	growthFactor := float64(percentileCumulativeWeight-previousCumulativeWeight) /
-		float64(fittingCumulativeWeight-previousCumulativeWeight)
+	sameWeight := 0.0
+	for i, mean := range d.centroids.mean[:size] {
+		if mean == x {
+			sameWeight += float64(d.centroids.weight[i])
+		}
+	}
+	if sameWeight > 0 {
+		return sameWeight
+	}
+	if size > 1 && ((x == d.min && x < d.centroids.mean[0]) || (x == d.max && x > d.centroids.mean[size-1])) {
+		return 1
+	}
+	return 0
+}
 
-	linearlyApproximateQuantile := previousCentroid.Mean + (growthFactor * (fittingCentroid.Mean - previousCentroid.Mean))
+// weightedAverage of two values, kept between them despite rounding.
+func weightedAverage(value1 float64, weight1 float64, value2 float64, weight2 float64) float64 {
+	average := (value1*weight1 + value2*weight2) / (weight1 + weight2)
+	return math.Max(math.Min(value1, value2), math.Min(average, math.Max(value1, value2)))
+}
 
-	return linearlyApproximateQuantile
+type options struct {
+	scale Scale
 }
 
-func NewTDigestWeightScaled(capacity int, bufferSize int) *TDigest {
-	centroids := NewCentroids(capacity)
-	scaling := NewWeightScaling(capacity)
+type Option func(*options)
+
+// WithScale selects scaling function bounding weights of centroids.
+// Defaults to ScaleWeight.
+func WithScale(scale Scale) Option {
+	return func(o *options) {
+		o.scale = scale
+	}
+}
+
+func NewTDigest(capacity int, bufferSize int, opts ...Option) (*TDigest, error) {
+	o := options{scale: ScaleWeight}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	maxWeights, err := o.scale.maxWeightFunc(capacity)
+	if err != nil {
+		return nil, err
+	}
 	return &TDigest{
 		capacity:           capacity,
-		centroids:          centroids,
-		quantileMaxWeights: scaling.MaxWeight,
+		centroids:          NewCentroids(capacity),
+		spare:              NewCentroids(capacity),
+		quantileMaxWeights: maxWeights,
+		scale:              o.scale,
 		unprocessed:        make(CentroidBuffer, 0, bufferSize),
 		bufferSize:         bufferSize,
-	}
+		min:                math.Inf(1),
+		max:                math.Inf(-1),
+	}, nil
+}
+
+// NewTDigestWeightScaled creates digest with ScaleWeight, which never fails.
+func NewTDigestWeightScaled(capacity int, bufferSize int) *TDigest {
+	digest, _ := NewTDigest(capacity, bufferSize, WithScale(ScaleWeight))
+	return digest
 }
@@ -5,6 +5,8 @@ import (
 	"hotline/metrics/tdigest"
 	"math"
 	"math/rand/v2"
+	"slices"
+	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -22,6 +24,38 @@ var _ = Describe("TDigest", func() {
 			Expect(centroids).To(HaveLen(1))
 		})
 
+		It("has zero min and max when empty", func() {
+			sut.forTDigest()
+
+			Expect(sut.tdigest.Min()).To(BeZero())
+			Expect(sut.tdigest.Max()).To(BeZero())
+		})
+
+		It("tracks min and max of buffered entries", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+			sut.AddEntry(-1.5)
+			sut.AddEntry(10.14)
+
+			Expect(sut.tdigest.Min()).To(Equal(-1.5))
+			Expect(sut.tdigest.Max()).To(Equal(10.14))
+		})
+
+		It("tracks count and sum of weighted entries", func() {
+			sut.forTDigest()
+			Expect(sut.tdigest.Count()).To(BeZero())
+			Expect(sut.tdigest.Sum()).To(BeZero())
+
+			sut.tdigest.AddToBuffer(1.5, 2)
+			sut.tdigest.AddToBuffer(-0.5, 1)
+			Expect(sut.tdigest.Count()).To(BeNumerically("==", 3))
+			Expect(sut.tdigest.Sum()).To(Equal(2.5))
+
+			sut.ToCentroids()
+			Expect(sut.tdigest.Count()).To(BeNumerically("==", 3))
+			Expect(sut.tdigest.Sum()).To(Equal(2.5))
+		})
+
 		It("will update centroid same weight", func() {
 			sut.forTDigest()
 			sut.AddEntry(3.14)
@@ -73,10 +107,10 @@ var _ = Describe("TDigest", func() {
 			centroids := sut.ToCentroids()
 			Expect(centroids).To(HaveLen(57))
 
-			Expect(sut.Quantile(0.70)).To(Equal(7.356594988103647))
-			Expect(sut.Quantile(0.80)).To(Equal(8.368116168239936))
-			Expect(sut.Quantile(0.90)).To(Equal(9.416024900604626))
-			Expect(sut.Quantile(0.99)).To(Equal(10.362849014104123))
+			Expect(sut.Quantile(0.70)).To(Equal(7.495221581710168))
+			Expect(sut.Quantile(0.80)).To(Equal(8.49089888215774))
+			Expect(sut.Quantile(0.90)).To(Equal(9.503100012677802))
+			Expect(sut.Quantile(0.99)).To(Equal(10.395198093184925))
 
 			totalWeight := uint64(0)
 			for _, centroid := range centroids {
@@ -116,6 +150,17 @@ var _ = Describe("TDigest", func() {
 			Expect(totalWeight).To(Equal(uint64(100_000)))
 		})
 
+		It("does not allocate when adding entries in steady state", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+
+			randomizer := rand.New(rand.NewPCG(1, 2))
+			allocations := testing.AllocsPerRun(10_000, func() {
+				sut.AddEntry(0.5 + (10 * randomizer.Float64()))
+			})
+			Expect(allocations).To(BeZero())
+		})
+
 		Context("Quantiles", func() {
 			It("should compute 0 for an empty tdigest", func() {
 				sut.forTDigest()
@@ -137,7 +182,7 @@ var _ = Describe("TDigest", func() {
 				sut.AddSimpleDataSet()
 
 				quantile := sut.Quantile(0.90)
-				Expect(quantile).Should(BeNumerically("~", 2.68, 0.01))
+				Expect(quantile).Should(BeNumerically("~", 3.04, 0.01))
 			})
 
 			It("should use same centroid for quantiles near the start", func() {
@@ -148,6 +193,66 @@ var _ = Describe("TDigest", func() {
 				Expect(quantile).Should(BeIdenticalTo(1.2))
 			})
 
+			It("should return exact min and max for extreme quantiles", func() {
+				sut.forTDigest()
+				sut.AddRandomEntries(100_000)
+
+				Expect(sut.Quantile(0)).To(Equal(sut.tdigest.Min()))
+				Expect(sut.Quantile(1)).To(Equal(sut.tdigest.Max()))
+				Expect(sut.tdigest.Min()).To(BeNumerically("~", 0.5, 0.001))
+				Expect(sut.tdigest.Max()).To(BeNumerically("~", 10.5, 0.001))
+			})
+
+			It("should spread outer half of the last centroid up to max", func() {
+				sut.tdigest, _ = tdigest.NewTDigest(100, 500, tdigest.WithScale(tdigest.ScaleK0))
+				for value := 1; value <= 100_000; value++ {
+					sut.AddEntry(float64(value))
+				}
+
+				centroids := sut.ToCentroids()
+				last := centroids[len(centroids)-1]
+				Expect(last.Mean).To(BeNumerically("<", 99_500))
+				Expect(sut.Quantile(0.999)).To(BeNumerically("~", 99_900, 10))
+			})
+
+			It("should estimate tail quantiles of uniform data precisely", func() {
+				sut.forTDigest()
+				sut.AddRandomEntries(100_000)
+
+				Expect(sut.Quantile(0.99)).To(BeNumerically("~", 10.4, 0.01))
+				Expect(sut.Quantile(0.999)).To(BeNumerically("~", 10.49, 0.01))
+			})
+
+			It("should return singletons instead of interpolating towards them", func() {
+				sut.forTDigest()
+				sut.AddEntry(1)
+				sut.AddEntry(2)
+				sut.AddEntry(3)
+				sut.AddEntry(4)
+
+				Expect(sut.Quantile(0.3)).To(Equal(2.0))
+				Expect(sut.Quantile(0.425)).To(Equal(2.0))
+			})
+
+			It("should interpolate from singleton to half of its weight", func() {
+				sut.forTDigest()
+				sut.AddEntry(1)
+				sut.AddEntry(2)
+				sut.tdigest.AddToBuffer(3, 5)
+				sut.AddEntry(4)
+
+				Expect(sut.Quantile(0.3125)).To(BeNumerically("~", 2.2, 1e-9))
+			})
+
+			It("should return max at center of last centroid of weight 2", func() {
+				sut.forTDigest()
+				sut.AddEntry(1)
+				sut.AddEntry(2)
+				sut.tdigest.AddToBuffer(3, 2)
+
+				Expect(sut.Quantile(0.75)).To(Equal(3.0))
+			})
+
 			It("should return NaN for quantiles out of range [0.0, 1.0]", func() {
 				sut.forTDigest()
 				sut.AddSimpleDataSet()
@@ -164,26 +269,26 @@ var _ = Describe("TDigest", func() {
 					{0, 1.2},
 					{0.05, 1.2},
 					{0.1, 1.2},
-					{0.15, 1.2},
-					{0.2, 1.2},
-					{0.25, 1.2},
-					{0.3, 1.35},
-					{0.35, 1.66},
-					{0.4, 1.98},
-					{0.45, 2.04},
-					{0.5, 2.11},
-					{0.55, 2.19},
-					{0.6, 2.25},
-					{0.65, 2.33},
-					{0.7, 2.4},
-					{0.75, 2.47},
-					{0.8, 2.54},
-					{0.85, 2.62},
-					{0.9, 2.68},
-					{0.95, 2.75},
-					{0.99, 2.81},
-					{0.999, 2.97},
-					{0.9999, 2.97},
+					{0.15, 1.27},
+					{0.2, 1.46},
+					{0.25, 1.66},
+					{0.3, 1.86},
+					{0.35, 2.02},
+					{0.4, 2.14},
+					{0.45, 2.25},
+					{0.5, 2.37},
+					{0.55, 2.49},
+					{0.6, 2.60},
+					{0.65, 2.72},
+					{0.7, 2.82},
+					{0.75, 2.88},
+					{0.8, 2.93},
+					{0.85, 2.99},
+					{0.9, 3.04},
+					{0.95, 3.09},
+					{0.99, 3.14},
+					{0.999, 3.14},
+					{0.9999, 3.14},
 					{1.0, 3.14},
 				}
 
@@ -196,6 +301,298 @@ var _ = Describe("TDigest", func() {
 		})
 	})
 
+	Context("CDF", func() {
+		sut := tdigestSut{}
+
+		It("is NaN for an empty digest", func() {
+			sut.forTDigest()
+
+			Expect(math.IsNaN(sut.tdigest.CDF(1))).To(BeTrue())
+			Expect(sut.tdigest.Rank(1)).To(BeZero())
+		})
+
+		It("is 0 below min and 1 above max", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+
+			Expect(sut.tdigest.CDF(1.19)).To(BeZero())
+			Expect(sut.tdigest.CDF(3.15)).To(Equal(1.0))
+		})
+
+		It("counts half of a single value", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+
+			Expect(sut.tdigest.CDF(3.14)).To(Equal(0.5))
+		})
+
+		It("interpolates single centroid between min and max", func() {
+			sut.tdigest = tdigest.NewTDigestWeightScaled(2, 500)
+			sut.AddEntry(1)
+			sut.AddEntry(3)
+
+			Expect(sut.ToCentroids()).To(HaveLen(1))
+			Expect(sut.tdigest.CDF(1.5)).To(Equal(0.25))
+		})
+
+		It("does not interpolate between singletons", func() {
+			sut.forTDigest()
+			sut.AddEntry(1)
+			sut.AddEntry(2)
+			sut.AddEntry(3)
+			sut.AddEntry(4)
+
+			Expect(sut.tdigest.CDF(1)).To(Equal(0.125))
+			Expect(sut.tdigest.CDF(1.5)).To(Equal(0.25))
+			Expect(sut.tdigest.CDF(2)).To(Equal(0.375))
+			Expect(sut.tdigest.CDF(3.9)).To(Equal(0.75))
+			Expect(sut.tdigest.CDF(4)).To(Equal(0.875))
+		})
+
+		It("excludes half of singleton next to heavier centroid", func() {
+			sut.forTDigest()
+			sut.AddEntry(1)
+			sut.tdigest.AddToBuffer(2, 10)
+			sut.AddEntry(3)
+
+			Expect(sut.tdigest.CDF(1.5)).To(BeNumerically("~", 3.5/12, 1e-9))
+			Expect(sut.tdigest.CDF(2.5)).To(BeNumerically("~", 8.5/12, 1e-9))
+		})
+
+		It("interpolates between exact min and max and outer centroids", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+			centroids := sut.ToCentroids()
+			first, last := centroids[0].Mean, centroids[len(centroids)-1].Mean
+			minimum, maximum := sut.tdigest.Min(), sut.tdigest.Max()
+
+			Expect(minimum).To(BeNumerically("<", first))
+			Expect(maximum).To(BeNumerically(">", last))
+			Expect(sut.tdigest.CDF(minimum)).To(Equal(0.5 / 100_000))
+			Expect(sut.tdigest.CDF(maximum)).To(Equal(1 - 0.5/100_000))
+			Expect(sut.tdigest.CDF((minimum + first) / 2)).To(BeNumerically(">", sut.tdigest.CDF(minimum)))
+			Expect(sut.tdigest.CDF((minimum + first) / 2)).To(BeNumerically("<", sut.tdigest.CDF(first)))
+			Expect(sut.tdigest.CDF((last + maximum) / 2)).To(BeNumerically("<", sut.tdigest.CDF(maximum)))
+			Expect(sut.tdigest.Rank(minimum)).To(BeNumerically("~", 1, 1e-9))
+		})
+
+		It("counts weight of centroids exactly at x by half", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+
+			Expect(sut.tdigest.CDF(1.98)).To(BeNumerically("~", (30+7.5)/113.0, 1e-9))
+		})
+
+		It("ranks values equal to x fully", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+			Expect(sut.tdigest.Rank(1.98)).To(BeNumerically("~", 45, 1e-9))
+
+			sut.forTDigest()
+			sut.AddEntry(5)
+			Expect(sut.tdigest.Rank(5)).To(Equal(1.0))
+			Expect(sut.tdigest.Rank(6)).To(Equal(1.0))
+
+			sut.forTDigest()
+			sut.AddEntry(1)
+			sut.AddEntry(2)
+			sut.AddEntry(3)
+			sut.AddEntry(4)
+			Expect(sut.tdigest.Rank(1)).To(Equal(1.0))
+			Expect(sut.tdigest.Rank(2)).To(Equal(2.0))
+			Expect(sut.tdigest.Rank(4)).To(Equal(4.0))
+		})
+
+		It("estimates fraction of uniform values below threshold", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+
+			for _, x := range []float64{0.6, 1, 3, 5.5, 8, 10, 10.4} {
+				Expect(sut.tdigest.CDF(x)).To(BeNumerically("~", (x-0.5)/10, 0.005), fmt.Sprintf("at %f", x))
+			}
+			Expect(sut.tdigest.Rank(5.5)).To(BeNumerically("~", 50_000, 500))
+		})
+
+		It("is inverse of quantile", func() {
+			sut.forTDigest()
+			values := logNormalValues(3, 100_000)
+			for _, value := range values {
+				sut.AddEntry(value)
+			}
+
+			for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.95, 0.99, 0.999} {
+				Expect(sut.tdigest.CDF(sut.Quantile(q))).To(BeNumerically("~", q, 0.001), fmt.Sprintf("at %f", q))
+			}
+		})
+
+		It("estimates good event ratio of skewed latencies", func() {
+			sut.forTDigest()
+			values := logNormalValues(3, 100_000)
+			for _, value := range values {
+				sut.AddEntry(value)
+			}
+			sorted := sortedValues(values)
+
+			for _, threshold := range []float64{0.5, 1, 2, 5, 10} {
+				index, _ := slices.BinarySearch(sorted, threshold)
+				exact := float64(index) / float64(len(sorted))
+				Expect(sut.tdigest.CDF(threshold)).To(BeNumerically("~", exact, 0.02), fmt.Sprintf("at %f", threshold))
+			}
+		})
+	})
+
+	Context("Merge", func() {
+		sut := tdigestSut{}
+
+		It("merging into an empty digest keeps centroids of other", func() {
+			sut.forTDigest()
+			other := tdigest.NewTDigestWeightScaled(100, 500)
+			other.AddToBuffer(3.14, 1)
+			other.AddToBuffer(10.14, 2)
+
+			sut.tdigest.Merge(other)
+
+			Expect(sut.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 3.14, Weight: 1},
+				{Mean: 10.14, Weight: 2},
+			}))
+		})
+
+		It("merges unprocessed buffer of other digest and leaves other untouched", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+			other := tdigest.NewTDigestWeightScaled(100, 500)
+			other.AddToBuffer(3.14, 1)
+			other.AddToBuffer(1.2, 1)
+
+			sut.tdigest.Merge(other)
+
+			Expect(sut.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 1.2, Weight: 1},
+				{Mean: 3.14, Weight: 2},
+			}))
+			Expect(other.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 1.2, Weight: 1},
+				{Mean: 3.14, Weight: 1},
+			}))
+		})
+
+		It("merges min and max", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+			other := tdigest.NewTDigestWeightScaled(100, 500)
+			other.AddToBuffer(1.2, 1)
+			other.AddToBuffer(10.14, 1)
+
+			sut.tdigest.MergeAll(other, tdigest.NewTDigestWeightScaled(100, 500))
+
+			Expect(sut.tdigest.Min()).To(Equal(1.2))
+			Expect(sut.tdigest.Max()).To(Equal(10.14))
+		})
+
+		It("merges count and sum", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+			other := tdigest.NewTDigestWeightScaled(100, 500)
+			other.AddToBuffer(1.25, 4)
+
+			sut.tdigest.MergeAll(other, other)
+
+			Expect(sut.tdigest.Count()).To(BeNumerically("==", 9))
+			Expect(sut.tdigest.Sum()).To(BeNumerically("~", 13.14, 1e-9))
+		})
+
+		It("ignores nil digests", func() {
+			sut.forTDigest()
+			sut.AddEntry(3.14)
+
+			sut.tdigest.MergeAll(nil, nil)
+
+			Expect(sut.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 3.14, Weight: 1},
+			}))
+		})
+
+		It("merging digest with itself doubles weights", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+
+			sut.tdigest.Merge(sut.tdigest)
+
+			Expect(sut.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 1.2, Weight: 60},
+				{Mean: 1.98, Weight: 30},
+				{Mean: 2.81, Weight: 132},
+				{Mean: 3.14, Weight: 4},
+			}))
+		})
+
+		It("keeps merged centroids bounded", func() {
+			sut.forTDigest()
+			parts := sut.splitRandomEntries(100_000, 10)
+
+			sut.tdigest.MergeAll(parts...)
+
+			Expect(len(sut.ToCentroids())).To(BeNumerically("<=", 100))
+			Expect(totalWeight(sut.ToCentroids())).To(Equal(uint64(100_000)))
+		})
+
+		It("does not grow buffer when merging more centroids than it holds", func() {
+			parts := sut.splitRandomEntries(100_000, 10)
+
+			created := testing.AllocsPerRun(100, func() {
+				tdigest.NewTDigestWeightScaled(100, 500)
+			})
+			merged := testing.AllocsPerRun(100, func() {
+				tdigest.NewTDigestWeightScaled(100, 500).MergeAll(parts...)
+			})
+			Expect(merged).To(Equal(created))
+		})
+
+		It("compresses centroids of others by own scale and capacity", func() {
+			sut.forTDigest()
+			other, err := tdigest.NewTDigest(1000, 500, tdigest.WithScale(tdigest.ScaleK1))
+			Expect(err).NotTo(HaveOccurred())
+			randomizer := rand.New(rand.NewPCG(190, 89992))
+			for range 100_000 {
+				other.AddToBuffer(0.5+(10*randomizer.Float64()), 1)
+			}
+
+			sut.tdigest.Merge(other)
+
+			Expect(sut.tdigest.Scale()).To(Equal(tdigest.ScaleWeight))
+			Expect(len(sut.ToCentroids())).To(BeNumerically("<=", 100))
+			Expect(totalWeight(sut.ToCentroids())).To(Equal(uint64(100_000)))
+		})
+
+		It("merged quantiles stay within error bounds of a single stream digest", func() {
+			for seed := range uint64(5) {
+				merged := tdigest.NewTDigestWeightScaled(100, 500)
+				single := tdigest.NewTDigestWeightScaled(100, 500)
+				parts := make([]*tdigest.TDigest, 10)
+				for i := range parts {
+					parts[i] = tdigest.NewTDigestWeightScaled(100, 500)
+				}
+
+				values := logNormalValues(seed, 100_000)
+				for i, value := range values {
+					single.AddToBuffer(value, 1)
+					parts[i%len(parts)].AddToBuffer(value, 1)
+				}
+				merged.MergeAll(parts...)
+
+				sorted := sortedValues(values)
+				for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+					bound := maxRankError(100, q)
+					Expect(sorted.rankError(single.Quantile(q), q)).To(
+						BeNumerically("<=", bound), fmt.Sprintf("single seed %d quantile %f", seed, q))
+					Expect(sorted.rankError(merged.Quantile(q), q)).To(
+						BeNumerically("<=", bound), fmt.Sprintf("merged seed %d quantile %f", seed, q))
+				}
+			}
+		})
+	})
+
 	Context("Centroids", func() {
 		sut := centroidsSut{}
 
@@ -265,6 +662,58 @@ var _ = Describe("TDigest", func() {
 			Expect(sum).To(Equal(uint64(26)))
 		})
 
+		It("unions centroids ordered by mean without compressing", func() {
+			sut.forCentroids()
+			sut.WithDataset()
+			other := tdigest.NewCentroids(10)
+			other.AddCentroid(0.5, 1)
+			other.AddCentroid(3.14, 2)
+			other.AddCentroid(11, 4)
+
+			union := sut.centroids.Union(other)
+
+			Expect(union.TotalWeight()).To(Equal(uint64(380)))
+			Expect(union.ToList()).To(Equal([]tdigest.Centroid{
+				{Mean: 0.5, Weight: 1},
+				{Mean: 1.618, Weight: 3},
+				{Mean: 2.718, Weight: 8},
+				{Mean: 3.14, Weight: 15},
+				{Mean: 3.14, Weight: 2},
+				{Mean: 4.765, Weight: 41},
+				{Mean: 5.635, Weight: 31},
+				{Mean: 6.123, Weight: 73},
+				{Mean: 7.123, Weight: 41},
+				{Mean: 8.156, Weight: 60},
+				{Mean: 9.635, Weight: 98},
+				{Mean: 10.635, Weight: 3},
+				{Mean: 11, Weight: 4},
+			}))
+		})
+
+		It("unions centroids of bigger means", func() {
+			sut.forCentroids()
+			sut.centroids.AddCentroid(2, 1)
+			sut.centroids.AddCentroid(3, 1)
+			other := tdigest.NewCentroids(10)
+			other.AddCentroid(1, 1)
+
+			union := sut.centroids.Union(other)
+
+			Expect(union.ToList()).To(Equal([]tdigest.Centroid{
+				{Mean: 1, Weight: 1},
+				{Mean: 2, Weight: 1},
+				{Mean: 3, Weight: 1},
+			}))
+		})
+
+		It("swaps centroids of buffer", func() {
+			buffer := tdigest.CentroidBuffer{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 3}}
+
+			buffer.Swap(0, 1)
+
+			Expect(buffer).To(Equal(tdigest.CentroidBuffer{{Mean: 2, Weight: 3}, {Mean: 1, Weight: 1}}))
+		})
+
 		It("finds last index of centroid with cumulative sum over total sum", func() {
 			sut.forCentroids()
 			sut.WithDataset()
@@ -302,12 +751,10 @@ func (s *centroidsSut) toList() []tdigest.Centroid {
 	return s.centroids.ToList()
 }
 
-
 func (s *centroidsSut) WithSingleCentroid() {
 	s.centroids.AddCentroid(3.14, 5)
 }
 
-
 func (s *centroidsSut) centroidWithCumulativeSum(sum uint64) (uint64, int, bool) {
 	return s.centroids.FittingCumulativeWeightCentroid(sum)
 }
@@ -374,6 +821,93 @@ func (t *tdigestSut) Quantile(percentile float64) float64 {
 	return t.tdigest.Quantile(percentile)
 }
 
+func (t *tdigestSut) splitRandomEntries(count int, parts int) []*tdigest.TDigest {
+	digests := make([]*tdigest.TDigest, parts)
+	for i := range digests {
+		digests[i] = tdigest.NewTDigestWeightScaled(100, 500)
+	}
+	randomizer := rand.New(rand.NewPCG(190, 89992))
+	for i := range count {
+		digests[i%parts].AddToBuffer(0.5+(10*randomizer.Float64()), 1)
+	}
+	return digests
+}
+
+func totalWeight(centroids []tdigest.Centroid) uint64 {
+	total := uint64(0)
+	for _, centroid := range centroids {
+		total += centroid.Weight
+	}
+	return total
+}
+
+func logNormalValues(seed uint64, count int) []float64 {
+	randomizer := rand.New(rand.NewPCG(seed, 89992))
+	values := make([]float64, count)
+	for i := range values {
+		values[i] = math.Exp(randomizer.NormFloat64())
+	}
+	return values
+}
+
+type sortedDataset []float64
+
+func sortedValues(values []float64) sortedDataset {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// rankError is the distance between requested quantile and the real rank
+// of estimated value.
+func (s sortedDataset) rankError(estimate float64, quantile float64) float64 {
+	index, _ := slices.BinarySearch(s, estimate)
+	return math.Abs(float64(index)/float64(len(s)) - quantile)
+}
+
+// maxRankError is the relative size of the biggest centroid the weight
+// scaling allows around quantile.
+func maxRankError(capacity int, quantile float64) float64 {
+	return 2 * math.Sin(math.Pi/float64(capacity)) * math.Sqrt(quantile*(1-quantile))
+}
+
+// BenchmarkAddToBuffer measures adding to a warmed up digest, including
+// periodic buffer processing.
+func BenchmarkAddToBuffer(b *testing.B) {
+	for _, scale := range []tdigest.Scale{tdigest.ScaleWeight, tdigest.ScaleK1, tdigest.ScaleK3} {
+		b.Run(scale.String(), func(b *testing.B) {
+			digest, _ := tdigest.NewTDigest(100, 500, tdigest.WithScale(scale))
+			values := logNormalValues(1, 100_000)
+			for _, value := range values {
+				digest.AddToBuffer(value, 1)
+			}
+
+			b.ReportAllocs()
+			index := 0
+			for b.Loop() {
+				digest.AddToBuffer(values[index], 1)
+				index = (index + 1) % len(values)
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	parts := make([]*tdigest.TDigest, 10)
+	for i := range parts {
+		parts[i] = tdigest.NewTDigestWeightScaled(100, 500)
+		for _, value := range logNormalValues(uint64(i), 10_000) {
+			parts[i].AddToBuffer(value, 1)
+		}
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		merged := tdigest.NewTDigestWeightScaled(100, 500)
+		merged.MergeAll(parts...)
+	}
+}
+
 func round(value float64, decimals uint32) float64 {
 	return math.Round(value*math.Pow(10, float64(decimals))) / math.Pow(10, float64(decimals))
 }
@@ -0,0 +1,271 @@
+package tdigest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrInvalidEncoding     = errors.New("invalid tdigest encoding")
+	ErrUnsupportedEncoding = errors.New("unsupported tdigest encoding version")
+)
+
+// Versions of binary form, stored as its first byte. Bump the version
+// whenever the layout changes and keep decoding of older versions.
+const (
+	encodingVersionWithoutExtremes = 1
+	encodingVersionWithoutSum      = 2
+	encodingVersion                = 3
+)
+
+// MarshalBinary encodes the digest as
+//
+//	version | scale | capacity | buffer size | min | max | sum | centroids count | centroids...
+//
+// where numbers are uvarints, min, max and sum are little endian IEEE-754 bits,
+// weights are uvarints and means are varint deltas of IEEE-754 bits of the
+// previous mean. Sorted means are close to each other, so their deltas stay
+// short. Buffer is processed before encoding.
+func (d *TDigest) MarshalBinary() ([]byte, error) {
+	d.processBuffer()
+
+	size := d.centroids.Size()
+	data := make([]byte, 0, 26+3*binary.MaxVarintLen64+size*(binary.MaxVarintLen64+binary.MaxVarintLen32))
+	data = append(data, encodingVersion, byte(d.scale))
+	data = binary.AppendUvarint(data, uint64(d.capacity))
+	data = binary.AppendUvarint(data, uint64(d.bufferSize))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(d.min))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(d.max))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(d.sum))
+	data = binary.AppendUvarint(data, uint64(size))
+
+	previousBits := uint64(0)
+	for index := range size {
+		bits := math.Float64bits(d.centroids.mean[index])
+		data = binary.AppendVarint(data, int64(bits-previousBits))
+		data = binary.AppendUvarint(data, d.centroids.weight[index])
+		previousBits = bits
+	}
+	return data, nil
+}
+
+func (d *TDigest) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("%w: too short", ErrInvalidEncoding)
+	}
+	version := data[0]
+	if version < encodingVersionWithoutExtremes || version > encodingVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedEncoding, version)
+	}
+	reader := &encodingReader{data: data[2:]}
+	scale := Scale(data[1])
+	capacity := reader.uvarint()
+	bufferSize := reader.uvarint()
+	minimum, maximum, sum := math.NaN(), math.NaN(), math.NaN()
+	if version >= encodingVersionWithoutSum {
+		minimum = reader.float64()
+		maximum = reader.float64()
+	}
+	if version >= encodingVersion {
+		sum = reader.float64()
+	}
+	size := reader.uvarint()
+	if reader.err != nil {
+		return reader.err
+	}
+	if size > uint64(len(reader.data)) {
+		return fmt.Errorf("%w: %d centroids do not fit %d bytes", ErrInvalidEncoding, size, len(reader.data))
+	}
+
+	restored, err := newEmptyTDigest(scale, capacity, bufferSize)
+	if err != nil {
+		return err
+	}
+
+	bits := uint64(0)
+	for range size {
+		bits += uint64(reader.varint())
+		weight := reader.uvarint()
+		if reader.err != nil {
+			return reader.err
+		}
+		if err := restored.restoreCentroid(math.Float64frombits(bits), weight); err != nil {
+			return err
+		}
+	}
+	if len(reader.data) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidEncoding, len(reader.data))
+	}
+	if err := restored.restoreExtremes(minimum, maximum); err != nil {
+		return err
+	}
+	restored.restoreSum(sum)
+
+	*d = *restored
+	return nil
+}
+
+type centroidJSON struct {
+	Mean   float64 `json:"mean"`
+	Weight uint64  `json:"weight"`
+}
+
+type tdigestJSON struct {
+	Scale      Scale          `json:"scale"`
+	Capacity   int            `json:"capacity"`
+	BufferSize int            `json:"bufferSize"`
+	Min        *float64       `json:"min,omitempty"`
+	Max        *float64       `json:"max,omitempty"`
+	Sum        *float64       `json:"sum,omitempty"`
+	Centroids  []centroidJSON `json:"centroids"`
+}
+
+// MarshalJSON encodes the digest in human readable form, mostly for
+// inspection. Buffer is processed before encoding.
+func (d *TDigest) MarshalJSON() ([]byte, error) {
+	d.processBuffer()
+
+	encoded := tdigestJSON{
+		Scale:      d.scale,
+		Capacity:   d.capacity,
+		BufferSize: d.bufferSize,
+		Centroids:  make([]centroidJSON, d.centroids.Size()),
+	}
+	for index, centroid := range d.centroids.ToList() {
+		encoded.Centroids[index] = centroidJSON(centroid)
+	}
+	if !d.isEmpty() {
+		minimum, maximum, sum := d.min, d.max, d.sum
+		encoded.Min = &minimum
+		encoded.Max = &maximum
+		encoded.Sum = &sum
+	}
+	return json.Marshal(encoded)
+}
+
+func (d *TDigest) UnmarshalJSON(data []byte) error {
+	var decoded tdigestJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if decoded.Capacity < 0 || decoded.BufferSize < 0 {
+		return fmt.Errorf("%w: negative capacity or buffer size", ErrInvalidEncoding)
+	}
+
+	restored, err := newEmptyTDigest(decoded.Scale, uint64(decoded.Capacity), uint64(decoded.BufferSize))
+	if err != nil {
+		return err
+	}
+	for _, centroid := range decoded.Centroids {
+		if err := restored.restoreCentroid(centroid.Mean, centroid.Weight); err != nil {
+			return err
+		}
+	}
+	minimum, maximum := math.NaN(), math.NaN()
+	if decoded.Min != nil && decoded.Max != nil {
+		minimum, maximum = *decoded.Min, *decoded.Max
+	}
+	if err := restored.restoreExtremes(minimum, maximum); err != nil {
+		return err
+	}
+	if decoded.Sum != nil {
+		restored.restoreSum(*decoded.Sum)
+	}
+
+	*d = *restored
+	return nil
+}
+
+func newEmptyTDigest(scale Scale, capacity uint64, bufferSize uint64) (*TDigest, error) {
+	if capacity == 0 || capacity > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: capacity %d", ErrInvalidEncoding, capacity)
+	}
+	if bufferSize == 0 || bufferSize > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: buffer size %d", ErrInvalidEncoding, bufferSize)
+	}
+	return NewTDigest(int(capacity), int(bufferSize), WithScale(scale))
+}
+
+// restoreCentroid appends decoded centroid, keeping centroids sorted.
+func (d *TDigest) restoreCentroid(mean float64, weight uint64) error {
+	if math.IsNaN(mean) || weight == 0 {
+		return fmt.Errorf("%w: centroid mean %v weight %d", ErrInvalidEncoding, mean, weight)
+	}
+	if size := d.centroids.Size(); size > 0 && d.centroids.mean[size-1] > mean {
+		return fmt.Errorf("%w: centroids are not sorted", ErrInvalidEncoding)
+	}
+	d.centroids.appendCentroid(mean, weight)
+	d.sum += mean * float64(weight)
+	return nil
+}
+
+// restoreSum sets decoded sum, NaN when it was not encoded and stays
+// estimated by centroids.
+func (d *TDigest) restoreSum(sum float64) {
+	if !math.IsNaN(sum) {
+		d.sum = sum
+	}
+}
+
+// restoreExtremes sets decoded min and max, NaN when they were not encoded
+// and have to be estimated by the outermost centroids.
+func (d *TDigest) restoreExtremes(minimum float64, maximum float64) error {
+	size := d.centroids.Size()
+	if size == 0 {
+		return nil
+	}
+	first, last := d.centroids.mean[0], d.centroids.mean[size-1]
+	if math.IsNaN(minimum) || math.IsNaN(maximum) {
+		minimum, maximum = first, last
+	}
+	if minimum > first || maximum < last {
+		return fmt.Errorf("%w: min %v and max %v do not cover centroids", ErrInvalidEncoding, minimum, maximum)
+	}
+	d.updateExtremes(minimum, maximum)
+	return nil
+}
+
+type encodingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *encodingReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: malformed uvarint", ErrInvalidEncoding)
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+// varint reads mean deltas, which are read only while reader has no error.
+func (r *encodingReader) varint() int64 {
+	value, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: malformed varint", ErrInvalidEncoding)
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *encodingReader) float64() float64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 8 {
+		r.err = fmt.Errorf("%w: malformed float", ErrInvalidEncoding)
+		return 0
+	}
+	value := math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return value
+}
@@ -0,0 +1,311 @@
+package tdigest_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hotline/metrics/tdigest"
+	"math"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encoding", func() {
+	sut := tdigestSut{}
+
+	Context("binary", func() {
+		It("round trips an empty digest", func() {
+			sut.forTDigest()
+
+			restored := sut.binaryRoundTrip()
+
+			Expect(restored.ToCentroids()).To(BeEmpty())
+			Expect(restored.Capacity()).To(Equal(100))
+			Expect(restored.BufferSize()).To(Equal(500))
+			Expect(restored.Scale()).To(Equal(tdigest.ScaleWeight))
+		})
+
+		It("round trips centroids exactly, including negative means", func() {
+			sut.forTDigest()
+			sut.tdigest.AddToBuffer(-3.5, 2)
+			sut.tdigest.AddToBuffer(-0.0001, 1)
+			sut.tdigest.AddToBuffer(0, 7)
+			sut.AddSimpleDataSet()
+
+			restored := sut.binaryRoundTrip()
+
+			Expect(restored.ToCentroids()).To(Equal(sut.ToCentroids()))
+			Expect(restored.Min()).To(Equal(-3.5))
+			Expect(restored.Max()).To(Equal(3.14))
+		})
+
+		It("round trips exact min and max hidden inside centroids", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+
+			restored := sut.binaryRoundTrip()
+
+			Expect(restored.Min()).To(Equal(sut.tdigest.Min()))
+			Expect(restored.Max()).To(Equal(sut.tdigest.Max()))
+			Expect(restored.Quantile(1)).To(Equal(sut.Quantile(1)))
+		})
+
+		It("round trips count and sum exactly", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+
+			restored := sut.binaryRoundTrip()
+
+			Expect(restored.Count()).To(Equal(sut.tdigest.Count()))
+			Expect(restored.Sum()).To(Equal(sut.tdigest.Sum()))
+		})
+
+		It("decodes version without sum, estimating it by centroids", func() {
+			// version 2, weight scale, capacity 100, buffer size 500, min 1, max 3,
+			// 2 centroids 1.5 x 3 and 2.5 x 1
+			data := []byte{2, 1, 100, 0xf4, 0x03}
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(1))
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(3))
+			data = append(data, 2)
+			data = binary.AppendVarint(data, int64(math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 3)
+			data = binary.AppendVarint(data, int64(math.Float64bits(2.5)-math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 1)
+
+			restored := &tdigest.TDigest{}
+			Expect(restored.UnmarshalBinary(data)).To(Succeed())
+
+			Expect(restored.Count()).To(BeNumerically("==", 4))
+			Expect(restored.Sum()).To(Equal(7.0))
+			Expect(restored.Min()).To(Equal(1.0))
+			Expect(restored.Max()).To(Equal(3.0))
+		})
+
+		It("decodes version without min and max, estimating them by outer centroids", func() {
+			// version 1, weight scale, capacity 100, buffer size 500, 2 centroids
+			// 1.5 x 3 and 2.5 x 1
+			data := []byte{1, 1, 100, 0xf4, 0x03, 2}
+			data = binary.AppendVarint(data, int64(math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 3)
+			data = binary.AppendVarint(data, int64(math.Float64bits(2.5)-math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 1)
+
+			restored := &tdigest.TDigest{}
+			Expect(restored.UnmarshalBinary(data)).To(Succeed())
+
+			Expect(restored.ToCentroids()).To(Equal([]tdigest.Centroid{
+				{Mean: 1.5, Weight: 3},
+				{Mean: 2.5, Weight: 1},
+			}))
+			Expect(restored.Capacity()).To(Equal(100))
+			Expect(restored.BufferSize()).To(Equal(500))
+			Expect(restored.Min()).To(Equal(1.5))
+			Expect(restored.Max()).To(Equal(2.5))
+		})
+
+		It("round trips buffered entries, capacity and buffer size", func() {
+			sut.forTDigestWithHihBuffer()
+			sut.AddRandomEntries(100_000)
+
+			restored := sut.binaryRoundTrip()
+
+			Expect(restored.ToCentroids()).To(Equal(sut.ToCentroids()))
+			Expect(restored.Capacity()).To(Equal(100))
+			Expect(restored.BufferSize()).To(Equal(10000))
+			Expect(restored.Quantile(0.99)).To(Equal(sut.Quantile(0.99)))
+		})
+
+		It("keeps encoding compact", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(100_000)
+
+			data, err := sut.tdigest.MarshalBinary()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(data)).To(BeNumerically("<", len(sut.ToCentroids())*16))
+		})
+
+		It("restored digest keeps accepting values", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+
+			restored := sut.binaryRoundTrip()
+			restored.AddToBuffer(1.2, 10)
+
+			Expect(restored.ToCentroids()[0]).To(Equal(tdigest.Centroid{Mean: 1.2, Weight: 40}))
+		})
+
+		It("rejects unknown version", func() {
+			sut.forTDigest()
+			data, _ := sut.tdigest.MarshalBinary()
+			data[0] = 99
+
+			err := (&tdigest.TDigest{}).UnmarshalBinary(data)
+			Expect(err).To(MatchError(tdigest.ErrUnsupportedEncoding))
+		})
+
+		It("rejects unknown scale", func() {
+			sut.forTDigest()
+			data, _ := sut.tdigest.MarshalBinary()
+			data[1] = 99
+
+			err := (&tdigest.TDigest{}).UnmarshalBinary(data)
+			Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+		})
+
+		It("rejects truncated data", func() {
+			sut.forTDigest()
+			sut.AddSimpleDataSet()
+			data, _ := sut.tdigest.MarshalBinary()
+
+			for length := range len(data) {
+				err := (&tdigest.TDigest{}).UnmarshalBinary(data[:length])
+				Expect(err).To(HaveOccurred())
+			}
+		})
+
+		It("rejects centroids without weight", func() {
+			// version 1, weight scale, capacity 100, buffer size 500, centroid 1.5 x 0
+			data := []byte{1, 1, 100, 0xf4, 0x03, 1}
+			data = binary.AppendVarint(data, int64(math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 0)
+
+			err := (&tdigest.TDigest{}).UnmarshalBinary(data)
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects min and max not covering centroids", func() {
+			// version 2, weight scale, capacity 100, buffer size 500, min 2, max 3,
+			// centroid 1.5 x 1
+			data := []byte{2, 1, 100, 0xf4, 0x03}
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(2))
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(3))
+			data = append(data, 1)
+			data = binary.AppendVarint(data, int64(math.Float64bits(1.5)))
+			data = binary.AppendUvarint(data, 1)
+
+			err := (&tdigest.TDigest{}).UnmarshalBinary(data)
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects trailing data", func() {
+			sut.forTDigest()
+			data, _ := sut.tdigest.MarshalBinary()
+
+			err := (&tdigest.TDigest{}).UnmarshalBinary(append(data, 1))
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+	})
+
+	Context("json", func() {
+		It("encodes digest in readable form", func() {
+			sut.forTDigest()
+			sut.tdigest.AddToBuffer(1.5, 3)
+
+			data, err := json.Marshal(sut.tdigest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(MatchJSON(`{
+				"scale": "weight",
+				"capacity": 100,
+				"bufferSize": 500,
+				"min": 1.5,
+				"max": 1.5,
+				"sum": 4.5,
+				"centroids": [{"mean": 1.5, "weight": 3}]
+			}`))
+		})
+
+		It("omits min and max of an empty digest", func() {
+			sut.forTDigest()
+
+			data, err := json.Marshal(sut.tdigest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(MatchJSON(`{
+				"scale": "weight",
+				"capacity": 100,
+				"bufferSize": 500,
+				"centroids": []
+			}`))
+		})
+
+		It("rejects min and max not covering centroids", func() {
+			err := json.Unmarshal([]byte(`{
+				"scale": "weight", "capacity": 100, "bufferSize": 500, "min": 2, "max": 3,
+				"centroids": [{"mean": 1, "weight": 1}]
+			}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("round trips digest", func() {
+			sut.forTDigest()
+			sut.AddRandomEntries(10_000)
+
+			data, err := json.Marshal(sut.tdigest)
+			Expect(err).NotTo(HaveOccurred())
+
+			restored := &tdigest.TDigest{}
+			Expect(json.Unmarshal(data, restored)).To(Succeed())
+			Expect(restored.ToCentroids()).To(Equal(sut.ToCentroids()))
+			Expect(restored.Capacity()).To(Equal(100))
+			Expect(restored.BufferSize()).To(Equal(500))
+			Expect(restored.Scale()).To(Equal(tdigest.ScaleWeight))
+			Expect(restored.Min()).To(Equal(sut.tdigest.Min()))
+			Expect(restored.Max()).To(Equal(sut.tdigest.Max()))
+			Expect(restored.Sum()).To(Equal(sut.tdigest.Sum()))
+		})
+
+		It("estimates missing sum by centroids", func() {
+			restored := &tdigest.TDigest{}
+			Expect(json.Unmarshal([]byte(`{
+				"scale": "weight", "capacity": 100, "bufferSize": 500,
+				"centroids": [{"mean": 1.5, "weight": 3}]
+			}`), restored)).To(Succeed())
+
+			Expect(restored.Sum()).To(Equal(4.5))
+		})
+
+		It("rejects unknown scale", func() {
+			err := json.Unmarshal([]byte(`{"scale": "banana", "capacity": 100, "bufferSize": 500}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+		})
+
+		It("rejects unsorted centroids", func() {
+			err := json.Unmarshal([]byte(`{
+				"scale": "weight", "capacity": 100, "bufferSize": 500,
+				"centroids": [{"mean": 2, "weight": 1}, {"mean": 1, "weight": 1}]
+			}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects negative capacity", func() {
+			err := json.Unmarshal([]byte(`{"scale": "weight", "capacity": -1, "bufferSize": 500}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects missing buffer size", func() {
+			err := json.Unmarshal([]byte(`{"scale": "weight", "capacity": 100}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects centroids without weight", func() {
+			err := json.Unmarshal([]byte(`{
+				"scale": "weight", "capacity": 100, "bufferSize": 500,
+				"centroids": [{"mean": 1, "weight": 0}]
+			}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+
+		It("rejects missing capacity", func() {
+			err := json.Unmarshal([]byte(`{"scale": "weight", "bufferSize": 500}`), &tdigest.TDigest{})
+			Expect(err).To(MatchError(tdigest.ErrInvalidEncoding))
+		})
+	})
+})
+
+func (t *tdigestSut) binaryRoundTrip() *tdigest.TDigest {
+	data, err := t.tdigest.MarshalBinary()
+	Expect(err).NotTo(HaveOccurred())
+
+	restored := &tdigest.TDigest{}
+	Expect(restored.UnmarshalBinary(data)).To(Succeed())
+	return restored
+}
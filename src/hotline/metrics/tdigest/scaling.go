@@ -1,6 +1,88 @@
 package tdigest
 
-import "math"
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrUnknownScale = errors.New("unknown scale")
+
+// Scale identifies the scaling function bounding weights of centroids.
+// It is persisted together with a digest so the digest can be restored
+// with the very same scaling.
+type Scale uint8
+
+const (
+	ScaleWeight Scale = iota + 1
+	ScaleK0
+	ScaleK1
+	ScaleK2
+	ScaleK3
+)
+
+func scales() []Scale {
+	return []Scale{ScaleWeight, ScaleK0, ScaleK1, ScaleK2, ScaleK3}
+}
+
+func (s Scale) String() string {
+	switch s {
+	case ScaleWeight:
+		return "weight"
+	case ScaleK0:
+		return "k0"
+	case ScaleK1:
+		return "k1"
+	case ScaleK2:
+		return "k2"
+	case ScaleK3:
+		return "k3"
+	default:
+		return fmt.Sprintf("scale(%d)", uint8(s))
+	}
+}
+
+func ParseScale(name string) (Scale, error) {
+	for _, scale := range scales() {
+		if scale.String() == name {
+			return scale, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownScale, name)
+}
+
+func (s Scale) MarshalText() ([]byte, error) {
+	if _, err := s.maxWeightFunc(1); err != nil {
+		return nil, err
+	}
+	return []byte(s.String()), nil
+}
+
+func (s *Scale) UnmarshalText(text []byte) error {
+	scale, err := ParseScale(string(text))
+	if err != nil {
+		return err
+	}
+	*s = scale
+	return nil
+}
+
+func (s Scale) maxWeightFunc(capacity int) (MaxWeightFunc, error) {
+	switch s {
+	case ScaleWeight:
+		return NewWeightScaling(capacity).MaxWeight, nil
+	case ScaleK0:
+		return NewK0Scaling(capacity).MaxWeight, nil
+	case ScaleK1:
+		return NewK1Scaling(capacity).MaxWeight, nil
+	case ScaleK2:
+		return NewK2Scaling(capacity).MaxWeight, nil
+	case ScaleK3:
+		return NewK3Scaling(capacity).MaxWeight, nil
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnknownScale, s)
+	}
+}
 
 type WeightScaling struct {
 	normalizer float64
@@ -18,3 +100,94 @@ func (s *WeightScaling) MaxWeight(quantile1, quantile2 float64, totalWeight uint
 	scale := s.normalizer * math.Sqrt(quantile*(1-quantile))
 	return scale*float64(totalWeight) + 1.0
 }
+
+// KScaling bounds centroids by scale function k from the paper. Centroid
+// may span at most a single unit of k, so its max weight is the distance
+// from quantile1 to the inverse of k(quantile1) + 1.
+type KScaling struct {
+	compression float64
+	k           func(quantile, normalizer float64) float64
+	inverse     func(k, normalizer float64) float64
+	normalizer  func(compression, totalWeight float64) float64
+}
+
+// NewK0Scaling gives all centroids the same weight.
+func NewK0Scaling(capacity int) *KScaling {
+	return &KScaling{
+		compression: float64(capacity),
+		k: func(quantile, normalizer float64) float64 {
+			return normalizer * quantile
+		},
+		inverse: func(k, normalizer float64) float64 {
+			return k / normalizer
+		},
+		normalizer: func(compression, _ float64) float64 {
+			return compression / 2
+		},
+	}
+}
+
+// NewK1Scaling is the arcsine scale, weight based scaling approximates it.
+func NewK1Scaling(capacity int) *KScaling {
+	return &KScaling{
+		compression: float64(capacity),
+		k: func(quantile, normalizer float64) float64 {
+			return normalizer * math.Asin(2*quantile-1)
+		},
+		inverse: func(k, normalizer float64) float64 {
+			return (math.Sin(k/normalizer) + 1) / 2
+		},
+		normalizer: func(compression, _ float64) float64 {
+			return compression / (2 * math.Pi)
+		},
+	}
+}
+
+// NewK2Scaling is the logit scale, shrinking centroids towards both tails.
+func NewK2Scaling(capacity int) *KScaling {
+	return &KScaling{
+		compression: float64(capacity),
+		k: func(quantile, normalizer float64) float64 {
+			return normalizer * math.Log(quantile/(1-quantile))
+		},
+		inverse: func(k, normalizer float64) float64 {
+			return 1 / (1 + math.Exp(-k/normalizer))
+		},
+		normalizer: func(compression, totalWeight float64) float64 {
+			return compression / logNormalizer(compression, totalWeight, 24)
+		},
+	}
+}
+
+// NewK3Scaling is the log scale, shrinking centroids towards both tails
+// even more than k2.
+func NewK3Scaling(capacity int) *KScaling {
+	return &KScaling{
+		compression: float64(capacity),
+		k: func(quantile, normalizer float64) float64 {
+			if quantile <= 0.5 {
+				return normalizer * math.Log(2*quantile)
+			}
+			return -normalizer * math.Log(2*(1-quantile))
+		},
+		inverse: func(k, normalizer float64) float64 {
+			if k <= 0 {
+				return math.Exp(k/normalizer) / 2
+			}
+			return 1 - math.Exp(-k/normalizer)/2
+		},
+		normalizer: func(compression, totalWeight float64) float64 {
+			return compression / logNormalizer(compression, totalWeight, 21)
+		},
+	}
+}
+
+func logNormalizer(compression, totalWeight, offset float64) float64 {
+	return 4*math.Log(math.Max(totalWeight/compression, 1)) + offset
+}
+
+func (s *KScaling) MaxWeight(quantile1, _ float64, totalWeight uint64) float64 {
+	normalizer := s.normalizer(s.compression, float64(totalWeight))
+	limit := s.inverse(s.k(quantile1, normalizer)+1, normalizer)
+	return (limit - quantile1) * float64(totalWeight)
+}
@@ -1,7 +1,11 @@
 package tdigest_test
 
 import (
+	"fmt"
 	"hotline/metrics/tdigest"
+	"math"
+	"math/rand/v2"
+	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -36,4 +40,137 @@ var _ = Describe("Scaling", func() {
 		}))
 
 	})
+
+	It("k0 scaling gives same capacity to all quantiles", func() {
+		scaling := tdigest.NewK0Scaling(100)
+
+		Expect(scaling.MaxWeight(0, 0, 1000)).To(BeNumerically("~", 20, 1e-9))
+		Expect(scaling.MaxWeight(0.5, 0.5, 1000)).To(BeNumerically("~", 20, 1e-9))
+		Expect(scaling.MaxWeight(0.98, 0.98, 1000)).To(BeNumerically("~", 20, 1e-9))
+	})
+
+	It("k1 scaling is symmetric and gives biggest capacity in the center", func() {
+		scaling := tdigest.NewK1Scaling(100)
+		totalWeight := uint64(100_000)
+
+		center := scaling.MaxWeight(0.5, 0.5, totalWeight)
+		Expect(center).To(BeNumerically(">", scaling.MaxWeight(0.1, 0.1, totalWeight)))
+		Expect(scaling.MaxWeight(0.1, 0.1, totalWeight)).To(BeNumerically(">", scaling.MaxWeight(0.01, 0.01, totalWeight)))
+		Expect(scaling.MaxWeight(0.01, 0.01, totalWeight)).To(BeNumerically(">", scaling.MaxWeight(0, 0, totalWeight)))
+	})
+
+	It("k2 and k3 scaling shrink tail centroids more than k1", func() {
+		totalWeight := uint64(100_000)
+		k1 := tdigest.NewK1Scaling(100)
+
+		for _, scaling := range []*tdigest.KScaling{tdigest.NewK2Scaling(100), tdigest.NewK3Scaling(100)} {
+			Expect(scaling.MaxWeight(0.999, 0.999, totalWeight)).To(BeNumerically("<", k1.MaxWeight(0.999, 0.999, totalWeight)))
+			Expect(scaling.MaxWeight(0.001, 0.001, totalWeight)).To(BeNumerically("<", k1.MaxWeight(0.001, 0.001, totalWeight)))
+			Expect(scaling.MaxWeight(0, 0, totalWeight)).To(BeNumerically("==", 0))
+		}
+	})
+
+	It("tail biased scales are more accurate at p99.9 of skewed latencies", func() {
+		values := logNormalValues(1, 100_000)
+		sorted := sortedValues(values)
+
+		uniform := digestOf(tdigest.ScaleK0, values)
+		for _, scale := range []tdigest.Scale{tdigest.ScaleK2, tdigest.ScaleK3} {
+			tailBiased := digestOf(scale, values)
+			Expect(sorted.rankError(tailBiased.Quantile(0.999), 0.999)).To(
+				BeNumerically("<", sorted.rankError(uniform.Quantile(0.999), 0.999)), scale.String())
+			Expect(len(tailBiased.ToCentroids())).To(BeNumerically("<=", 100), scale.String())
+		}
+	})
+
+	It("creates digest with selected scale", func() {
+		digest, err := tdigest.NewTDigest(100, 500, tdigest.WithScale(tdigest.ScaleK3))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest.Scale()).To(Equal(tdigest.ScaleK3))
+
+		digest, err = tdigest.NewTDigest(100, 500)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest.Scale()).To(Equal(tdigest.ScaleWeight))
+	})
+
+	It("fails to create digest with unknown scale", func() {
+		_, err := tdigest.NewTDigest(100, 500, tdigest.WithScale(tdigest.Scale(99)))
+		Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+	})
+
+	It("fails to marshal unknown scale", func() {
+		_, err := tdigest.Scale(99).MarshalText()
+		Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+	})
+
+	It("parses scale from its name", func() {
+		for _, name := range []string{"weight", "k0", "k1", "k2", "k3"} {
+			scale, err := tdigest.ParseScale(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scale.String()).To(Equal(name))
+		}
+
+		_, err := tdigest.ParseScale("banana")
+		Expect(err).To(MatchError(tdigest.ErrUnknownScale))
+	})
 })
+
+func digestOf(scale tdigest.Scale, values []float64) *tdigest.TDigest {
+	digest, err := tdigest.NewTDigest(100, 500, tdigest.WithScale(scale))
+	Expect(err).NotTo(HaveOccurred())
+	for _, value := range values {
+		digest.AddToBuffer(value, 1)
+	}
+	return digest
+}
+
+// BenchmarkScaleAccuracy reports rank error of p50, p99 and p99.9 for every
+// scale on skewed latency distributions.
+func BenchmarkScaleAccuracy(b *testing.B) {
+	distributions := []struct {
+		name   string
+		sample func(randomizer *rand.Rand) float64
+	}{
+		{"lognormal", func(randomizer *rand.Rand) float64 {
+			return math.Exp(randomizer.NormFloat64())
+		}},
+		{"pareto", func(randomizer *rand.Rand) float64 {
+			return 0.05 / math.Pow(1-randomizer.Float64(), 1/1.5)
+		}},
+	}
+	quantiles := []struct {
+		name     string
+		quantile float64
+	}{
+		{"p50", 0.5},
+		{"p99", 0.99},
+		{"p99.9", 0.999},
+	}
+	scales := []tdigest.Scale{tdigest.ScaleWeight, tdigest.ScaleK0, tdigest.ScaleK1, tdigest.ScaleK2, tdigest.ScaleK3}
+
+	for _, distribution := range distributions {
+		randomizer := rand.New(rand.NewPCG(190, 89992))
+		values := make([]float64, 100_000)
+		for i := range values {
+			values[i] = distribution.sample(randomizer)
+		}
+		sorted := sortedValues(values)
+
+		for _, scale := range scales {
+			b.Run(fmt.Sprintf("%s/%s", distribution.name, scale), func(b *testing.B) {
+				var digest *tdigest.TDigest
+				for b.Loop() {
+					digest, _ = tdigest.NewTDigest(100, 500, tdigest.WithScale(scale))
+					for _, value := range values {
+						digest.AddToBuffer(value, 1)
+					}
+					digest.ToCentroids()
+				}
+				for _, q := range quantiles {
+					b.ReportMetric(sorted.rankError(digest.Quantile(q.quantile), q.quantile), q.name+"-rank-err")
+				}
+				b.ReportMetric(float64(len(digest.ToCentroids())), "centroids")
+			})
+		}
+	}
+}
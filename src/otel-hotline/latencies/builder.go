@@ -0,0 +1,182 @@
+package latencies
+
+import (
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// metricsBuilder emits latency percentiles, exponential histograms or
+// summaries, request counts per status and error ratio of series into single
+// metrics batch. Every data point starts at start time of its series.
+type metricsBuilder struct {
+	labels         outputLabels
+	enabledOutputs map[string]bool
+	md             pmetric.Metrics
+	ts             pcommon.Timestamp
+	seriesCount    int
+
+	latencyDps    pmetric.NumberDataPointSlice
+	histogramDps  pmetric.ExponentialHistogramDataPointSlice
+	summaryDps    pmetric.SummaryDataPointSlice
+	requestsDps   pmetric.NumberDataPointSlice
+	errorRatioDps pmetric.NumberDataPointSlice
+}
+
+func (c *latenciesConnector) newMetricsBuilder(now time.Time) *metricsBuilder {
+	b := &metricsBuilder{
+		labels:         c.labels,
+		enabledOutputs: c.enabledOutputs,
+		md:             pmetric.NewMetrics(),
+		ts:             pcommon.NewTimestampFromTime(now),
+	}
+	temporality := pmetric.AggregationTemporalityDelta
+	if c.cfg.AggregationTemporality == temporalityCumulative {
+		temporality = pmetric.AggregationTemporalityCumulative
+	}
+	sm := b.md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+
+	if b.enabledOutputs[outputGauge] {
+		latencyMetric := sm.Metrics().AppendEmpty()
+		latencyMetric.SetName(c.cfg.MetricName)
+		latencyMetric.SetUnit(metricUnit)
+		b.latencyDps = latencyMetric.SetEmptyGauge().DataPoints()
+	}
+
+	if b.enabledOutputs[outputExponentialHistogram] {
+		histogramMetric := sm.Metrics().AppendEmpty()
+		histogramMetric.SetName(c.cfg.HistogramMetricName)
+		histogramMetric.SetUnit(metricUnit)
+		histogram := histogramMetric.SetEmptyExponentialHistogram()
+		histogram.SetAggregationTemporality(temporality)
+		b.histogramDps = histogram.DataPoints()
+	}
+
+	if b.enabledOutputs[outputSummary] {
+		summaryMetric := sm.Metrics().AppendEmpty()
+		summaryMetric.SetName(c.cfg.SummaryMetricName)
+		summaryMetric.SetUnit(metricUnit)
+		b.summaryDps = summaryMetric.SetEmptySummary().DataPoints()
+	}
+
+	requestsMetric := sm.Metrics().AppendEmpty()
+	requestsMetric.SetName(c.cfg.RequestsMetricName)
+	requestsMetric.SetUnit(requestsMetricUnit)
+	if c.cfg.Window.enabled() {
+		// counts of overlapping windows can not be summed
+		b.requestsDps = requestsMetric.SetEmptyGauge().DataPoints()
+	} else {
+		requests := requestsMetric.SetEmptySum()
+		requests.SetAggregationTemporality(temporality)
+		requests.SetIsMonotonic(true)
+		b.requestsDps = requests.DataPoints()
+	}
+
+	errorRatioMetric := sm.Metrics().AppendEmpty()
+	errorRatioMetric.SetName(c.cfg.ErrorRatioMetricName)
+	errorRatioMetric.SetUnit(errorRatioMetricUnit)
+	b.errorRatioDps = errorRatioMetric.SetEmptyGauge().DataPoints()
+	return b
+}
+
+func (b *metricsBuilder) isEmpty() bool {
+	return b.seriesCount == 0
+}
+
+func (b *metricsBuilder) addSeries(key seriesKey, latencies *latencySeries) {
+	b.seriesCount++
+	start := pcommon.NewTimestampFromTime(latencies.startTime)
+
+	if b.enabledOutputs[outputGauge] {
+		for _, percentile := range latencies.settings.percentiles {
+			dp := b.latencyDps.AppendEmpty()
+			dp.SetStartTimestamp(start)
+			dp.SetTimestamp(b.ts)
+			dp.SetDoubleValue(latencies.digest.Quantile(percentile))
+			b.putSeriesAttributes(dp.Attributes(), key)
+			dp.Attributes().PutStr(b.labels.quantile, strconv.FormatFloat(percentile, 'g', -1, 64))
+		}
+	}
+
+	if b.enabledOutputs[outputExponentialHistogram] {
+		dp := b.histogramDps.AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(b.ts)
+		b.putSeriesAttributes(dp.Attributes(), key)
+		putExponentialHistogram(dp, latencies)
+	}
+
+	if b.enabledOutputs[outputSummary] {
+		dp := b.summaryDps.AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(b.ts)
+		b.putSeriesAttributes(dp.Attributes(), key)
+		putSummary(dp, latencies)
+	}
+
+	for _, status := range allSpanStatuses() {
+		_, count := latencies.statuses.ComputePercentile(status)
+		if count == 0 {
+			continue
+		}
+		dp := b.requestsDps.AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(b.ts)
+		dp.SetIntValue(count)
+		b.putSeriesAttributes(dp.Attributes(), key)
+		dp.Attributes().PutStr(b.labels.outcome, status.outcome)
+		if status.statusClass != "" {
+			dp.Attributes().PutStr(b.labels.statusClass, status.statusClass)
+		}
+	}
+
+	dp := b.errorRatioDps.AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(b.ts)
+	dp.SetDoubleValue(latencies.errorRatio())
+	b.putSeriesAttributes(dp.Attributes(), key)
+}
+
+func putExponentialHistogram(dp pmetric.ExponentialHistogramDataPoint, latencies *latencySeries) {
+	buckets := latencies.histogram.ExponentialBuckets()
+	count := buckets.ZeroCount
+	for _, bucketCount := range buckets.Counts {
+		count += bucketCount
+	}
+
+	dp.SetScale(buckets.Scale)
+	dp.SetCount(count)
+	dp.SetSum(latencies.digest.Sum())
+	dp.SetZeroThreshold(buckets.ZeroThreshold)
+	dp.SetZeroCount(buckets.ZeroCount)
+	dp.Positive().SetOffset(buckets.Offset)
+	dp.Positive().BucketCounts().FromRaw(buckets.Counts)
+	dp.SetMin(latencies.digest.Min())
+	dp.SetMax(latencies.digest.Max())
+}
+
+func putSummary(dp pmetric.SummaryDataPoint, latencies *latencySeries) {
+	dp.SetCount(latencies.digest.Count())
+	dp.SetSum(latencies.digest.Sum())
+	for _, percentile := range latencies.settings.percentiles {
+		quantile := dp.QuantileValues().AppendEmpty()
+		quantile.SetQuantile(percentile)
+		quantile.SetValue(latencies.digest.Quantile(percentile))
+	}
+}
+
+// putSeriesAttributes puts attributes of series, overflow series has only
+// the overflow attribute.
+func (b *metricsBuilder) putSeriesAttributes(attrs pcommon.Map, key seriesKey) {
+	if key.overflow {
+		attrs.PutBool(overflowAttribute, true)
+		return
+	}
+	attrs.PutStr(b.labels.integrationID, key.integrationID)
+	attrs.PutStr(b.labels.route, key.route)
+	attrs.PutStr(b.labels.method, key.method)
+	attrs.PutStr(b.labels.kind, key.kind)
+	putDimensionAttributes(attrs, b.labels.dimensions, key.dimensions)
+}
@@ -2,6 +2,7 @@ package latencies
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"go.opentelemetry.io/collector/component"
@@ -13,35 +14,188 @@ const (
 	defaultIntegrationIDAttribute = "x-integration-id"
 	defaultRouteAttribute         = "http.route"
 	defaultMethodAttribute        = "http.request.method"
+	urlPathAttribute              = "url.path"
+	urlFullAttribute              = "url.full"
 	defaultInterval               = 10 * time.Second
 	defaultMetricName             = "http.span.request.duration"
+	defaultStatusCodeAttribute    = "http.response.status_code"
+	defaultRequestsMetricName     = "http.span.request.count"
+	defaultErrorRatioMetricName   = "http.span.request.error_ratio"
+	defaultHistogramMetricName    = "http.span.request.duration.histogram"
+	defaultHistogramScale         = 4
+	defaultHistogramMaxSize       = 160
+	defaultSeriesExpiration       = 5 * time.Minute
+	defaultSummaryMetricName      = "http.span.request.duration.summary"
+
+	outputGauge                = "gauge"
+	outputExponentialHistogram = "exponential_histogram"
+	outputSummary              = "summary"
+
+	temporalityDelta      = "delta"
+	temporalityCumulative = "cumulative"
 )
 
+// allOutputs lists every latency output.
+func allOutputs() []string {
+	return []string{outputGauge, outputExponentialHistogram, outputSummary}
+}
+
 func defaultPercentiles() []float64 {
 	return []float64{0.99, 0.8, 0.75}
 }
 
 // Config configures the latencies connector that turns HTTP server span
-// durations into latency percentile metrics per integration id and route.
+// durations and statuses into latency percentile, request count and error
+// ratio metrics per integration id and route.
 type Config struct {
 	// Percentiles is the set of quantiles to compute, each in the open
 	// interval (0, 1). Defaults to p99, p80, p75.
 	Percentiles []float64 `mapstructure:"percentiles"`
+	// Integrations override percentiles, digest capacity and span kinds of
+	// integrations with id matching the key, a glob pattern such as
+	// "payments-*". Exact id wins over patterns, longer patterns win over
+	// shorter ones.
+	Integrations map[string]IntegrationOverride `mapstructure:"integrations"`
 	// Interval is how often percentile metrics are computed and emitted.
 	Interval time.Duration `mapstructure:"interval"`
+	// Window emits metrics over sliding window instead of single interval.
+	Window WindowConfig `mapstructure:"window"`
 	// IntegrationIDAttribute is the span attribute key carrying the
-	// integration id used to partition metrics.
+	// integration id used to partition metrics, also the emitted attribute
+	// of resolved integration id.
 	IntegrationIDAttribute string `mapstructure:"integration_id_attribute"`
+	// IntegrationIDRules resolve integration id of every span, the first
+	// matching rule wins. Spans unresolved by any rule are dropped.
+	// Defaults to the integration id attribute alone.
+	IntegrationIDRules []IntegrationRule `mapstructure:"integration_id_rules"`
 	// RouteAttribute is the span attribute key carrying the HTTP route.
 	RouteAttribute string `mapstructure:"route_attribute"`
 	// MethodAttribute is the span attribute key carrying the HTTP method.
 	MethodAttribute string `mapstructure:"method_attribute"`
+	// RouteNormalization derives route of spans missing the route
+	// attribute from their URL.
+	RouteNormalization RouteNormalization `mapstructure:"route_normalization"`
+	// RouteDefault is the route of spans missing both the route attribute
+	// and URL. Spans without route are dropped when empty.
+	RouteDefault string `mapstructure:"route_default"`
+	// MethodDefault is the method of spans missing the method attribute.
+	// Spans without method are dropped when empty.
+	MethodDefault string `mapstructure:"method_default"`
+	// Dimensions are additional span or resource attributes partitioning
+	// series, e.g. server.address, peer.service, tenant or region.
+	Dimensions []Dimension `mapstructure:"dimensions"`
+	// OutputAttributes names attributes of emitted data points.
+	OutputAttributes OutputAttributes `mapstructure:"output_attributes"`
 	// SpanKinds is the set of span kinds to measure. Valid values are
 	// "unspecified", "internal", "server", "client", "producer" and
 	// "consumer". Defaults to all kinds.
 	SpanKinds []string `mapstructure:"span_kinds"`
 	// MetricName is the name of the emitted latency metric.
 	MetricName string `mapstructure:"metric_name"`
+	// StatusCodeAttribute is the span attribute key carrying the HTTP
+	// response status code, used with span status to classify requests.
+	StatusCodeAttribute string `mapstructure:"status_code_attribute"`
+	// RequestsMetricName is the name of the emitted request count metric,
+	// partitioned by outcome and HTTP response status class.
+	RequestsMetricName string `mapstructure:"requests_metric_name"`
+	// ErrorRatioMetricName is the name of the emitted metric with share of
+	// requests failed with error or timeout.
+	ErrorRatioMetricName string `mapstructure:"error_ratio_metric_name"`
+	// Outputs selects emitted latency representations. "gauge" emits a
+	// gauge per percentile, "exponential_histogram" emits an OTLP
+	// exponential histogram per series, which backends can re-aggregate,
+	// "summary" emits an OTLP summary with count, sum and percentiles per
+	// series. Summaries are cumulative by definition, so "summary" requires
+	// "cumulative" aggregation_temporality. Defaults to gauge.
+	Outputs []string `mapstructure:"outputs"`
+	// HistogramMetricName is the name of the emitted exponential histogram.
+	HistogramMetricName string `mapstructure:"histogram_metric_name"`
+	// HistogramScale is the scale of emitted exponential histograms in
+	// [-10, 20], bucket bounds grow by factor 2^(2^-scale). Higher scale
+	// gives more precise but more buckets. Defaults to 4.
+	HistogramScale int32 `mapstructure:"histogram_scale"`
+	// HistogramMaxSize is the maximum number of buckets of exponential
+	// histograms, scale is reduced as latencies arrive until they fit, so
+	// memory of every series stays bounded. Defaults to 160.
+	HistogramMaxSize int `mapstructure:"histogram_max_size"`
+	// SummaryMetricName is the name of the emitted summary.
+	SummaryMetricName string `mapstructure:"summary_metric_name"`
+	// AggregationTemporality is "delta" to emit values accumulated since
+	// the previous emission, or "cumulative" to emit values accumulated
+	// since the series started, as expected by Prometheus. Data points
+	// start at the first span of their series. Defaults to delta.
+	AggregationTemporality string `mapstructure:"aggregation_temporality"`
+	// SeriesExpiration is how long series without spans are kept. Expired
+	// cumulative series stop being emitted and restart from zero when spans
+	// return, delta series then start at their first span instead of their
+	// previous emission. Defaults to 5 minutes.
+	SeriesExpiration time.Duration `mapstructure:"series_expiration"`
+	// MaxSeries limits number of series accumulated between emissions,
+	// spans of further series are accumulated in a single series marked
+	// with otel.metric.overflow attribute. Zero means unlimited.
+	MaxSeries int `mapstructure:"max_series"`
+	// MaxSeriesPerIntegration limits number of series of every integration
+	// id, further series overflow as with MaxSeries. Zero means unlimited.
+	MaxSeriesPerIntegration int `mapstructure:"max_series_per_integration"`
+}
+
+// OutputAttributes names attributes of emitted data points, so emitted
+// schema does not depend on attribute keys of incoming spans.
+type OutputAttributes struct {
+	// Rename maps input attribute key of integration id, route, method or
+	// dimension to emitted attribute. Unmapped keys are emitted unchanged.
+	Rename map[string]string `mapstructure:"rename"`
+	// Kind is the attribute of span kind. Defaults to "kind".
+	Kind string `mapstructure:"kind"`
+	// Quantile is the attribute of percentile gauges. Defaults to
+	// "quantile".
+	Quantile string `mapstructure:"quantile"`
+	// Outcome is the attribute of request outcome. Defaults to "outcome".
+	Outcome string `mapstructure:"outcome"`
+	// StatusClass is the attribute of HTTP response status class. Defaults
+	// to "http.response.status_class".
+	StatusClass string `mapstructure:"status_class"`
+}
+
+// outputLabels are resolved names of emitted attributes.
+type outputLabels struct {
+	integrationID string
+	route         string
+	method        string
+	kind          string
+	quantile      string
+	outcome       string
+	statusClass   string
+	dimensions    []string
+}
+
+func (c *Config) outputLabels() outputLabels {
+	rename := func(key string) string {
+		if renamed, ok := c.OutputAttributes.Rename[key]; ok {
+			return renamed
+		}
+		return key
+	}
+	labels := outputLabels{
+		integrationID: rename(c.IntegrationIDAttribute),
+		route:         rename(c.RouteAttribute),
+		method:        rename(c.MethodAttribute),
+		kind:          c.OutputAttributes.Kind,
+		quantile:      c.OutputAttributes.Quantile,
+		outcome:       c.OutputAttributes.Outcome,
+		statusClass:   c.OutputAttributes.StatusClass,
+	}
+	for _, dimension := range c.Dimensions {
+		labels.dimensions = append(labels.dimensions, rename(dimension.Name))
+	}
+	return labels
+}
+
+// all lists every emitted attribute.
+func (l outputLabels) all() []string {
+	return append([]string{
+		l.integrationID, l.route, l.method, l.kind, l.quantile, l.outcome, l.statusClass,
+	}, l.dimensions...)
 }
 
 func createDefaultConfig() component.Config {
@@ -51,8 +205,28 @@ func createDefaultConfig() component.Config {
 		IntegrationIDAttribute: defaultIntegrationIDAttribute,
 		RouteAttribute:         defaultRouteAttribute,
 		MethodAttribute:        defaultMethodAttribute,
+		RouteNormalization: RouteNormalization{
+			URLAttributes: []string{urlPathAttribute, urlFullAttribute},
+			DetectIDs:     true,
+		},
 		SpanKinds:              allSpanKinds(),
 		MetricName:             defaultMetricName,
+		StatusCodeAttribute:    defaultStatusCodeAttribute,
+		RequestsMetricName:     defaultRequestsMetricName,
+		ErrorRatioMetricName:   defaultErrorRatioMetricName,
+		Outputs:                []string{outputGauge},
+		HistogramMetricName:    defaultHistogramMetricName,
+		HistogramScale:         defaultHistogramScale,
+		HistogramMaxSize:       defaultHistogramMaxSize,
+		SummaryMetricName:      defaultSummaryMetricName,
+		AggregationTemporality: temporalityDelta,
+		SeriesExpiration:       defaultSeriesExpiration,
+		OutputAttributes: OutputAttributes{
+			Kind:        kindAttribute,
+			Quantile:    quantileAttribute,
+			Outcome:     outcomeAttribute,
+			StatusClass: statusClassAttribute,
+		},
 	}
 }
 
@@ -64,20 +238,35 @@ func (c *Config) Validate() error {
 	if len(c.Percentiles) == 0 {
 		return fmt.Errorf("at least one percentile must be configured")
 	}
-	for _, p := range c.Percentiles {
-		if p <= 0 || p >= 1 {
-			return fmt.Errorf("percentile must be in the open interval (0, 1), got %v", p)
-		}
+	if err := validatePercentiles(c.Percentiles); err != nil {
+		return err
 	}
 	if c.IntegrationIDAttribute == "" {
 		return fmt.Errorf("integration_id_attribute must not be empty")
 	}
+	for _, rule := range c.IntegrationIDRules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
 	if c.RouteAttribute == "" {
 		return fmt.Errorf("route_attribute must not be empty")
 	}
 	if c.MethodAttribute == "" {
 		return fmt.Errorf("method_attribute must not be empty")
 	}
+	if err := c.validateWindow(); err != nil {
+		return err
+	}
+	if err := c.validateRouteNormalization(); err != nil {
+		return err
+	}
+	if err := c.validateDimensions(); err != nil {
+		return err
+	}
+	if err := c.validateOutputLabels(); err != nil {
+		return err
+	}
 	if len(c.SpanKinds) == 0 {
 		return fmt.Errorf("at least one span kind must be configured")
 	}
@@ -86,9 +275,107 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("unknown span kind %q, valid values are %v", kind, allSpanKinds())
 		}
 	}
+	for pattern, override := range c.Integrations {
+		if err := override.validate(pattern); err != nil {
+			return err
+		}
+	}
 	if c.MetricName == "" {
 		return fmt.Errorf("metric_name must not be empty")
 	}
+	if c.StatusCodeAttribute == "" {
+		return fmt.Errorf("status_code_attribute must not be empty")
+	}
+	if c.RequestsMetricName == "" {
+		return fmt.Errorf("requests_metric_name must not be empty")
+	}
+	if c.ErrorRatioMetricName == "" {
+		return fmt.Errorf("error_ratio_metric_name must not be empty")
+	}
+	if len(c.Outputs) == 0 {
+		return fmt.Errorf("at least one output must be configured")
+	}
+	for _, output := range c.Outputs {
+		if !slices.Contains(allOutputs(), output) {
+			return fmt.Errorf("unknown output %q, valid values are %v", output, allOutputs())
+		}
+	}
+	if c.HistogramMetricName == "" {
+		return fmt.Errorf("histogram_metric_name must not be empty")
+	}
+	if c.SummaryMetricName == "" {
+		return fmt.Errorf("summary_metric_name must not be empty")
+	}
+	if c.AggregationTemporality != temporalityDelta && c.AggregationTemporality != temporalityCumulative {
+		return fmt.Errorf("aggregation_temporality must be %q or %q, got %q", temporalityDelta, temporalityCumulative, c.AggregationTemporality)
+	}
+	if slices.Contains(c.Outputs, outputSummary) && c.AggregationTemporality != temporalityCumulative {
+		return fmt.Errorf("%q output requires %q aggregation_temporality, summaries are cumulative", outputSummary, temporalityCumulative)
+	}
+	if c.SeriesExpiration <= 0 {
+		return fmt.Errorf("series_expiration must be positive, got %s", c.SeriesExpiration)
+	}
+	if c.HistogramScale < -10 || c.HistogramScale > 20 {
+		return fmt.Errorf("histogram_scale must be in [-10, 20], got %d", c.HistogramScale)
+	}
+	if c.HistogramMaxSize < 2 {
+		return fmt.Errorf("histogram_max_size must be at least 2, got %d", c.HistogramMaxSize)
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series must not be negative, got %d", c.MaxSeries)
+	}
+	if c.MaxSeriesPerIntegration < 0 {
+		return fmt.Errorf("max_series_per_integration must not be negative, got %d", c.MaxSeriesPerIntegration)
+	}
+	return nil
+}
+
+func validatePercentiles(percentiles []float64) error {
+	for _, p := range percentiles {
+		if p <= 0 || p >= 1 {
+			return fmt.Errorf("percentile must be in the open interval (0, 1), got %v", p)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateRouteNormalization() error {
+	for _, key := range c.RouteNormalization.URLAttributes {
+		if key == "" {
+			return fmt.Errorf("route_normalization url_attributes must not be empty")
+		}
+	}
+	for _, rule := range c.RouteNormalization.Rules {
+		if rule.Template == "" {
+			return fmt.Errorf("route rule %q must have template", rule.Pattern)
+		}
+	}
+	_, err := newRouteNormalizer(c.RouteNormalization)
+	return err
+}
+
+func (c *Config) validateDimensions() error {
+	for _, dimension := range c.Dimensions {
+		if dimension.Name == "" {
+			return fmt.Errorf("dimension name must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateOutputLabels checks every emitted attribute has unique non-empty
+// name, including dimensions colliding with built-in attributes.
+func (c *Config) validateOutputLabels() error {
+	seen := make(map[string]bool)
+	for _, label := range c.outputLabels().all() {
+		if label == "" {
+			return fmt.Errorf("output attribute names must not be empty")
+		}
+		if seen[label] {
+			return fmt.Errorf("duplicate output attribute %q", label)
+		}
+		seen[label] = true
+	}
 	return nil
 }
 
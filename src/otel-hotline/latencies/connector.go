@@ -3,6 +3,7 @@ package latencies
 import (
 	"context"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,23 +11,35 @@ import (
 	"go.opentelemetry.io/collector/connector"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
-	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.uber.org/zap"
+	"hotline/clock"
+	"hotline/metrics"
 	"hotline/metrics/tdigest"
 )
 
 const (
-	metricUnit = "s"
+	metricUnit           = "s"
+	requestsMetricUnit   = "{request}"
+	errorRatioMetricUnit = "1"
 
+	// tdigestCapacity is the default digest capacity of integrations.
 	tdigestCapacity   = 100
 	tdigestBufferSize = 500
+	// stepBufferSize buffers fewer latencies in digests of sliding window
+	// steps, every series keeps a digest per step.
+	stepBufferSize = 50
 
-	integrationIDAttribute = "x-integration-id"
-	routeAttribute         = "http.route"
-	methodAttribute        = "http.request.method"
-	kindAttribute          = "kind"
-	quantileAttribute      = "quantile"
+	// histogramZeroThreshold collapses latencies under microsecond into
+	// zero bucket of exponential histogram.
+	histogramZeroThreshold = 1e-6
+
+	kindAttribute        = "kind"
+	quantileAttribute    = "quantile"
+	outcomeAttribute     = "outcome"
+	statusClassAttribute = "http.response.status_class"
+	errorTypeAttribute   = "error.type"
+	overflowAttribute    = "otel.metric.overflow"
 
 	kindUnspecified = "unspecified"
 	kindInternal    = "internal"
@@ -66,6 +79,69 @@ func spanKindLabel(kind ptrace.SpanKind) string {
 	}
 }
 
+const (
+	outcomeOK      = "ok"
+	outcomeError   = "error"
+	outcomeTimeout = "timeout"
+)
+
+// spanStatus classifies span outcome and class of its HTTP response status
+// code, empty when span has no status code.
+type spanStatus struct {
+	outcome     string
+	statusClass string
+}
+
+// allSpanStatuses lists every status tracked by series status histogram.
+func allSpanStatuses() []spanStatus {
+	var statuses []spanStatus
+	for _, outcome := range []string{outcomeOK, outcomeError, outcomeTimeout} {
+		for _, statusClass := range []string{"", "1xx", "2xx", "3xx", "4xx", "5xx"} {
+			statuses = append(statuses, spanStatus{outcome: outcome, statusClass: statusClass})
+		}
+	}
+	return statuses
+}
+
+// spanStatusOf classifies span by its status, HTTP response status code and
+// error type. Explicit Ok status wins, HTTP 5xx responses are errors of all
+// spans and 4xx responses errors of client spans only, following HTTP
+// semantic conventions. Timeouts are errors reported with 504 status code,
+// with 408 status code of client spans or with error type naming timeout or
+// deadline.
+func spanStatusOf(span ptrace.Span, kind string, statusCodeAttribute string) spanStatus {
+	code, hasCode := intAttr(span.Attributes(), statusCodeAttribute)
+	status := spanStatus{outcome: outcomeOK}
+	if hasCode && code >= 100 && code < 600 {
+		status.statusClass = strconv.FormatInt(code/100, 10) + "xx"
+	}
+
+	switch {
+	case span.Status().Code() == ptrace.StatusCodeOk:
+	case isTimeout(span, kind, code):
+		status.outcome = outcomeTimeout
+	case span.Status().Code() == ptrace.StatusCodeError,
+		code >= 500 && code < 600,
+		kind == kindClient && code >= 400 && code < 500:
+		status.outcome = outcomeError
+	}
+	return status
+}
+
+// isTimeout ignores 408 of server spans, request timeout of caller is not a
+// failure of the server.
+func isTimeout(span ptrace.Span, kind string, code int64) bool {
+	if code == 504 || (kind == kindClient && code == 408) {
+		return true
+	}
+	errorType, ok := stringAttr(span.Attributes(), errorTypeAttribute)
+	if !ok {
+		return false
+	}
+	errorType = strings.ToLower(errorType)
+	return strings.Contains(errorType, "timeout") || strings.Contains(errorType, "deadline")
+}
+
 var connectorCapabilities = consumer.Capabilities{MutatesData: false}
 
 type seriesKey struct {
@@ -73,35 +149,155 @@ type seriesKey struct {
 	route         string
 	method        string
 	kind          string
+	dimensions    string
+	overflow      bool
+}
+
+// spanSample is latency and status of single span.
+type spanSample struct {
+	latencySeconds float64
+	status         spanStatus
+}
+
+// latencySeries accumulates span latencies and statuses of a single series
+// since its start time. Histogram is kept only for exponential histogram
+// output.
+type latencySeries struct {
+	settings  *integrationSettings
+	startTime time.Time
+	// updatedAt is time of emission which first saw emittedCount spans,
+	// used to expire cumulative series.
+	updatedAt    time.Time
+	emittedCount int64
+	digest       *tdigest.TDigest
+	histogram    *metrics.ExponentialHistogram
+	statuses     *metrics.TagHistogram[spanStatus]
+}
+
+func (s *latencySeries) Add(sample spanSample) {
+	s.digest.AddToBuffer(sample.latencySeconds, 1)
+	if s.histogram != nil {
+		s.histogram.Add(sample.latencySeconds)
+	}
+	s.statuses.Add(sample.status)
+}
+
+// Merge adds series of other step of the same series window.
+func (s *latencySeries) Merge(other *latencySeries) error {
+	s.digest.Merge(other.digest)
+	if s.histogram != nil {
+		if err := s.histogram.Merge(other.histogram); err != nil {
+			return err
+		}
+	}
+	return s.statuses.Merge(other.statuses)
+}
+
+// errorRatio is share of spans failed with error or timeout.
+func (s *latencySeries) errorRatio() float64 {
+	var failed int64
+	for _, status := range allSpanStatuses() {
+		if status.outcome == outcomeOK {
+			continue
+		}
+		_, count := s.statuses.ComputePercentile(status)
+		failed += count
+	}
+	return float64(failed) / float64(s.statuses.Total())
+}
+
+type seriesStore = metrics.ShardedStore[seriesKey, spanSample, *latencySeries]
+
+// sampleBatch buffers samples of single traces batch, either of series
+// store or of window store.
+type sampleBatch interface {
+	Add(key seriesKey, sample spanSample)
+	CommitWith(admit func(key seriesKey) seriesKey)
 }
 
 type latenciesConnector struct {
-	cfg          *Config
-	logger       *zap.Logger
-	next         consumer.Metrics
-	enabledKinds map[string]bool
+	cfg            *Config
+	labels         outputLabels
+	logger         *zap.Logger
+	next           consumer.Metrics
+	overrides      *integrationOverrides
+	enabledOutputs map[string]bool
+	integrations   *integrationResolver
+	routes         *routeNormalizer
+
+	series    *seriesStore
+	windows   *windowStore
+	limiter   *seriesLimiter
+	telemetry *telemetry
 
-	mu      sync.Mutex
-	digests map[seriesKey]*tdigest.TDigest
+	// flushMu serializes emissions and guards lastEmitted, times of the
+	// previous emission of delta series.
+	flushMu     sync.Mutex
+	lastEmitted map[seriesKey]time.Time
 
-	ticker   *time.Ticker
+	clock    clock.Clock
+	ticker   clock.Ticker
 	doneCh   chan struct{}
 	stopOnce sync.Once
 }
 
-func newLatenciesConnector(set connector.Settings, cfg *Config, next consumer.Metrics) *latenciesConnector {
-	enabledKinds := make(map[string]bool, len(cfg.SpanKinds))
-	for _, kind := range cfg.SpanKinds {
-		enabledKinds[kind] = true
+func newLatenciesConnector(set connector.Settings, cfg *Config, next consumer.Metrics) (*latenciesConnector, error) {
+	enabledOutputs := make(map[string]bool, len(cfg.Outputs))
+	for _, output := range cfg.Outputs {
+		enabledOutputs[output] = true
+	}
+
+	tel, err := newTelemetry(set.TelemetrySettings)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := newRouteNormalizer(cfg.RouteNormalization)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &latenciesConnector{
+		cfg:            cfg,
+		labels:         cfg.outputLabels(),
+		logger:         set.Logger,
+		next:           next,
+		overrides:      newIntegrationOverrides(cfg),
+		enabledOutputs: enabledOutputs,
+		integrations:   newIntegrationResolver(cfg),
+		routes:         routes,
+		limiter:        newSeriesLimiter(cfg.MaxSeries, cfg.MaxSeriesPerIntegration),
+		telemetry:      tel,
+		clock:          clock.SystemClock{},
+		lastEmitted:    make(map[seriesKey]time.Time),
+		doneCh:         make(chan struct{}),
+	}
+	if cfg.Window.enabled() {
+		c.windows = metrics.NewShardedStore[seriesKey, spanSample](0, c.newSeriesWindow)
+	} else {
+		c.series = metrics.NewShardedStore[seriesKey, spanSample](0, c.newLatencySeries)
+	}
+	return c, nil
+}
+
+func (c *latenciesConnector) newLatencySeries(key seriesKey) *latencySeries {
+	return c.newBufferedSeries(key, tdigestBufferSize)
+}
+
+func (c *latenciesConnector) newBufferedSeries(key seriesKey, bufferSize int) *latencySeries {
+	settings := c.overrides.seriesSettings(key)
+	now := c.clock.Now()
+	series := &latencySeries{
+		settings:  settings,
+		startTime: now,
+		updatedAt: now,
+		digest:    tdigest.NewTDigestWeightScaled(settings.digestCapacity, bufferSize),
+		statuses:  metrics.NewTagsHistogram(allSpanStatuses()),
 	}
-	return &latenciesConnector{
-		cfg:          cfg,
-		logger:       set.Logger,
-		next:         next,
-		enabledKinds: enabledKinds,
-		digests:      make(map[seriesKey]*tdigest.TDigest),
-		doneCh:       make(chan struct{}),
+	if c.enabledOutputs[outputExponentialHistogram] {
+		// scale and max size are validated by config
+		series.histogram, _ = metrics.NewExponentialHistogram(c.cfg.HistogramScale, c.cfg.HistogramMaxSize, histogramZeroThreshold)
 	}
+	return series
 }
 
 func (c *latenciesConnector) Capabilities() consumer.Capabilities {
@@ -109,11 +305,16 @@ func (c *latenciesConnector) Capabilities() consumer.Capabilities {
 }
 
 func (c *latenciesConnector) Start(_ context.Context, _ component.Host) error {
-	c.ticker = time.NewTicker(c.cfg.Interval)
+	interval := c.cfg.Interval
+	if c.cfg.Window.enabled() {
+		interval = c.cfg.Window.step(c.cfg.Interval)
+	}
+	c.ticker = c.clock.NewTicker(interval)
 	go c.run()
 	c.logger.Info(
 		"latencies connector started",
-		zap.String("interval", c.cfg.Interval.String()),
+		zap.String("interval", interval.String()),
+		zap.String("window", c.cfg.Window.Size.String()),
 	)
 	return nil
 }
@@ -126,7 +327,7 @@ func (c *latenciesConnector) Shutdown(ctx context.Context) error {
 		close(c.doneCh)
 	})
 	// Emit whatever has accumulated since the last tick.
-	return c.flush(ctx, time.Now())
+	return c.flush(ctx, c.clock.Now())
 }
 
 func (c *latenciesConnector) run() {
@@ -134,7 +335,7 @@ func (c *latenciesConnector) run() {
 		select {
 		case <-c.doneCh:
 			return
-		case now := <-c.ticker.C:
+		case now := <-c.ticker.C():
 			if err := c.flush(context.Background(), now); err != nil {
 				c.logger.Error("failed to emit latency metrics", zap.Error(err))
 			}
@@ -142,9 +343,17 @@ func (c *latenciesConnector) run() {
 	}
 }
 
-func (c *latenciesConnector) ConsumeTraces(_ context.Context, td ptrace.Traces) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// ConsumeTraces buffers latencies of the whole batch locally and commits
+// them to the series store at once, so concurrent pipelines contend only
+// on shards of the series they share. New series are admitted on commit,
+// under the same shard locks as series are drained.
+func (c *latenciesConnector) ConsumeTraces(ctx context.Context, td ptrace.Traces) error {
+	batch := c.newBatch()
+	var counts spanCounts
+	defer func() {
+		batch.CommitWith(c.admitter(&counts))
+		c.telemetry.record(ctx, counts)
+	}()
 
 	resourceSpans := td.ResourceSpans()
 	for i := 0; i < resourceSpans.Len(); i++ {
@@ -152,86 +361,159 @@ func (c *latenciesConnector) ConsumeTraces(_ context.Context, td ptrace.Traces)
 		for j := 0; j < scopeSpans.Len(); j++ {
 			spans := scopeSpans.At(j).Spans()
 			for k := 0; k < spans.Len(); k++ {
-				c.recordSpan(spans.At(k))
+				c.recordSpan(batch, &counts, spans.At(k), resourceSpans.At(i).Resource().Attributes())
 			}
 		}
 	}
 	return nil
 }
 
-func (c *latenciesConnector) recordSpan(span ptrace.Span) {
+func (c *latenciesConnector) newBatch() sampleBatch {
+	if c.windows != nil {
+		return c.windows.NewBatch()
+	}
+	return c.series.NewBatch()
+}
+
+func (c *latenciesConnector) recordSpan(batch sampleBatch, counts *spanCounts, span ptrace.Span, resourceAttrs pcommon.Map) {
 	kind := spanKindLabel(span.Kind())
-	if !c.enabledKinds[kind] {
+	if !c.overrides.anyKind[kind] {
 		return
 	}
 
 	attrs := span.Attributes()
-	integrationID, ok := stringAttr(attrs, c.cfg.IntegrationIDAttribute)
+	integrationID, ok := c.integrations.resolve(attrs, resourceAttrs)
 	if !ok {
+		counts.drop(dropReasonUnresolvedIntegration)
 		return
 	}
-	route, ok := stringAttr(attrs, c.cfg.RouteAttribute)
+	if !c.overrides.settingsOf(integrationID).enabledKinds[kind] {
+		return
+	}
+	route, ok := c.routeOf(attrs)
 	if !ok {
+		counts.drop(dropReasonMissingAttribute)
 		return
 	}
-	method, ok := stringAttr(attrs, c.cfg.MethodAttribute)
+	method, ok := attrOrDefault(attrs, c.cfg.MethodAttribute, c.cfg.MethodDefault)
 	if !ok {
+		counts.drop(dropReasonMissingAttribute)
 		return
 	}
 
 	latencySeconds := durationSeconds(span.StartTimestamp(), span.EndTimestamp())
 	if latencySeconds < 0 {
+		counts.drop(dropReasonNegativeDuration)
 		return
 	}
 
-	key := seriesKey{integrationID: integrationID, route: route, method: method, kind: kind}
-	digest, found := c.digests[key]
-	if !found {
-		digest = tdigest.NewTDigestWeightScaled(tdigestCapacity, tdigestBufferSize)
-		c.digests[key] = digest
+	key := seriesKey{
+		integrationID: integrationID,
+		route:         route,
+		method:        method,
+		kind:          kind,
+		dimensions:    dimensionValues(c.cfg.Dimensions, attrs, resourceAttrs),
 	}
-	digest.AddToBuffer(latencySeconds, 1)
+	batch.Add(key, spanSample{
+		latencySeconds: latencySeconds,
+		status:         spanStatusOf(span, kind, c.cfg.StatusCodeAttribute),
+	})
 }
 
-// flush computes the configured percentiles for every active series, emits
-// them as a single metrics batch and resets the accumulators (tumbling
-// window with delta semantics).
+// admitter admits new series to limits counting spans of series over
+// limits, nil limiter admits all series without a check.
+func (c *latenciesConnector) admitter(counts *spanCounts) func(key seriesKey) seriesKey {
+	if c.limiter == nil {
+		return nil
+	}
+	return func(key seriesKey) seriesKey {
+		key, admitted := c.limiter.admit(key)
+		if !admitted {
+			counts.overflowed++
+		}
+		return key
+	}
+}
+
+// routeOf returns route attribute, route normalized from URL or default
+// route, in this order.
+func (c *latenciesConnector) routeOf(attrs pcommon.Map) (string, bool) {
+	if route, ok := stringAttr(attrs, c.cfg.RouteAttribute); ok {
+		return route, true
+	}
+	if route, ok := c.routes.route(attrs); ok {
+		return route, true
+	}
+	return c.cfg.RouteDefault, c.cfg.RouteDefault != ""
+}
+
+// flush emits metrics of every series as a single metrics batch. Delta
+// temporality resets the accumulators (tumbling window), cumulative keeps
+// accumulating since the series started. Sliding window keeps accumulators
+// of its steps, see flushWindows.
 func (c *latenciesConnector) flush(ctx context.Context, now time.Time) error {
-	c.mu.Lock()
-	digests := c.digests
-	c.digests = make(map[seriesKey]*tdigest.TDigest)
-	c.mu.Unlock()
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
 
-	if len(digests) == 0 {
+	if c.windows != nil {
+		return c.flushWindows(ctx, now)
+	}
+	builder := c.newMetricsBuilder(now)
+	if c.cfg.AggregationTemporality == temporalityCumulative {
+		c.flushCumulative(builder, now)
+	} else {
+		c.flushDelta(builder, now)
+	}
+	if builder.isEmpty() {
 		return nil
 	}
+	return c.next.ConsumeMetrics(ctx, builder.md)
+}
 
-	md := c.buildMetrics(digests, now)
-	return c.next.ConsumeMetrics(ctx, md)
-}
-
-func (c *latenciesConnector) buildMetrics(digests map[seriesKey]*tdigest.TDigest, now time.Time) pmetric.Metrics {
-	md := pmetric.NewMetrics()
-	sm := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
-	metric := sm.Metrics().AppendEmpty()
-	metric.SetName(c.cfg.MetricName)
-	metric.SetUnit(metricUnit)
-	dps := metric.SetEmptyGauge().DataPoints()
-
-	ts := pcommon.NewTimestampFromTime(now)
-	for key, digest := range digests {
-		for _, percentile := range c.cfg.Percentiles {
-			dp := dps.AppendEmpty()
-			dp.SetTimestamp(ts)
-			dp.SetDoubleValue(digest.Quantile(percentile))
-			dp.Attributes().PutStr(integrationIDAttribute, key.integrationID)
-			dp.Attributes().PutStr(routeAttribute, key.route)
-			dp.Attributes().PutStr(methodAttribute, key.method)
-			dp.Attributes().PutStr(kindAttribute, key.kind)
-			dp.Attributes().PutStr(quantileAttribute, strconv.FormatFloat(percentile, 'g', -1, 64))
+// flushDelta drains series accumulated since the previous flush. Data points
+// start at the previous emission of their series, so consecutive points
+// leave no gaps, or at the first span of series not emitted within series
+// expiration. Series limits are reset under the same shard locks as the
+// accumulators are drained, so series admitted after reset are never drained
+// into the previous interval.
+func (c *latenciesConnector) flushDelta(builder *metricsBuilder, now time.Time) {
+	for key, latencies := range c.series.DrainWith(c.limiter.reset) {
+		if emitted, found := c.lastEmitted[key]; found {
+			latencies.startTime = emitted
+		}
+		c.lastEmitted[key] = now
+		builder.addSeries(key, latencies)
+	}
+	for key, emitted := range c.lastEmitted {
+		if c.expired(emitted, now) {
+			delete(c.lastEmitted, key)
 		}
 	}
-	return md
+}
+
+// flushCumulative emits every series since its start and removes series
+// without spans within series expiration. Series limits are rebuilt from
+// series kept.
+func (c *latenciesConnector) flushCumulative(builder *metricsBuilder, now time.Time) {
+	c.limiter.reset()
+	c.series.DeleteFunc(func(key seriesKey, latencies *latencySeries) bool {
+		if count := latencies.statuses.Total(); count != latencies.emittedCount {
+			latencies.emittedCount = count
+			latencies.updatedAt = now
+		}
+		if c.expired(latencies.updatedAt, now) {
+			return true
+		}
+		builder.addSeries(key, latencies)
+		if !key.overflow {
+			c.limiter.admit(key)
+		}
+		return false
+	})
+}
+
+func (c *latenciesConnector) expired(updatedAt time.Time, now time.Time) bool {
+	return now.Sub(updatedAt) >= c.cfg.SeriesExpiration
 }
 
 func stringAttr(attrs pcommon.Map, key string) (string, bool) {
@@ -242,6 +524,31 @@ func stringAttr(attrs pcommon.Map, key string) (string, bool) {
 	return v.AsString(), true
 }
 
+// attrOrDefault falls back to non-empty default value for missing attribute.
+func attrOrDefault(attrs pcommon.Map, key string, defaultValue string) (string, bool) {
+	value, ok := stringAttr(attrs, key)
+	if !ok && defaultValue != "" {
+		return defaultValue, true
+	}
+	return value, ok
+}
+
+func intAttr(attrs pcommon.Map, key string) (int64, bool) {
+	v, ok := attrs.Get(key)
+	if !ok {
+		return 0, false
+	}
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		return v.Int(), true
+	case pcommon.ValueTypeStr:
+		parsed, err := strconv.ParseInt(v.Str(), 10, 64)
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
+
 func durationSeconds(start, end pcommon.Timestamp) float64 {
 	return float64(end.AsTime().Sub(start.AsTime())) / float64(time.Second)
 }
@@ -0,0 +1,64 @@
+package latencies
+
+import (
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// Dimension is an additional span or resource attribute partitioning
+// latency series.
+type Dimension struct {
+	// Name is the attribute key, looked up in span attributes first and in
+	// resource attributes second.
+	Name string `mapstructure:"name"`
+	// Default is the value of spans missing the attribute. Without default
+	// the dimension is omitted from series of such spans.
+	Default *string `mapstructure:"default"`
+}
+
+const missingDimensionValue = "-"
+
+// dimensionValues looks up values of configured dimensions and encodes them
+// into a comparable part of series key. Every value is prefixed with its
+// length, missing values are encoded as "-".
+func dimensionValues(dimensions []Dimension, spanAttrs pcommon.Map, resourceAttrs pcommon.Map) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+	var encoded strings.Builder
+	for _, dimension := range dimensions {
+		value, found := stringAttr(spanAttrs, dimension.Name)
+		if !found {
+			value, found = stringAttr(resourceAttrs, dimension.Name)
+		}
+		if !found && dimension.Default != nil {
+			value, found = *dimension.Default, true
+		}
+
+		if !found {
+			encoded.WriteString(missingDimensionValue)
+			continue
+		}
+		encoded.WriteString(strconv.Itoa(len(value)))
+		encoded.WriteByte(':')
+		encoded.WriteString(value)
+	}
+	return encoded.String()
+}
+
+// putDimensionAttributes decodes dimension values of series key into
+// attributes with given labels, skipping missing ones.
+func putDimensionAttributes(attrs pcommon.Map, labels []string, encoded string) {
+	for _, label := range labels {
+		if strings.HasPrefix(encoded, missingDimensionValue) {
+			encoded = encoded[len(missingDimensionValue):]
+			continue
+		}
+		lengthText, rest, _ := strings.Cut(encoded, ":")
+		length, _ := strconv.Atoi(lengthText)
+		attrs.PutStr(label, rest[:length])
+		encoded = rest[length:]
+	}
+}
@@ -17,5 +17,5 @@ func NewFactory() connector.Factory {
 }
 
 func createTracesToMetrics(_ context.Context, set connector.Settings, cfg component.Config, next consumer.Metrics) (connector.Traces, error) {
-	return newLatenciesConnector(set, cfg.(*Config), next), nil
+	return newLatenciesConnector(set, cfg.(*Config), next)
 }
@@ -2,6 +2,11 @@ package latencies
 
 import (
 	"context"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +17,9 @@ import (
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"hotline/clock"
 )
 
 func TestFactoryCreateDefaultConfig(t *testing.T) {
@@ -53,6 +61,101 @@ func TestConfigValidate(t *testing.T) {
 		{"no span kinds", func(c *Config) { c.SpanKinds = nil }, true},
 		{"unknown span kind", func(c *Config) { c.SpanKinds = []string{"banana"} }, true},
 		{"valid subset of span kinds", func(c *Config) { c.SpanKinds = []string{"server", "client"} }, false},
+		{"empty status code attr", func(c *Config) { c.StatusCodeAttribute = "" }, true},
+		{"empty requests metric name", func(c *Config) { c.RequestsMetricName = "" }, true},
+		{"empty error ratio metric name", func(c *Config) { c.ErrorRatioMetricName = "" }, true},
+		{"no outputs", func(c *Config) { c.Outputs = nil }, true},
+		{"unknown output", func(c *Config) { c.Outputs = []string{"banana"} }, true},
+		{"all outputs", func(c *Config) {
+			c.Outputs = []string{"gauge", "exponential_histogram", "summary"}
+			c.AggregationTemporality = "cumulative"
+		}, false},
+		{"empty histogram metric name", func(c *Config) { c.HistogramMetricName = "" }, true},
+		{"empty summary metric name", func(c *Config) { c.SummaryMetricName = "" }, true},
+		{"cumulative temporality", func(c *Config) { c.AggregationTemporality = "cumulative" }, false},
+		{"dimensions", func(c *Config) { c.Dimensions = []Dimension{{Name: "server.address"}, {Name: "region"}} }, false},
+		{"empty dimension name", func(c *Config) { c.Dimensions = []Dimension{{Name: ""}} }, true},
+		{"duplicate dimension", func(c *Config) { c.Dimensions = []Dimension{{Name: "region"}, {Name: "region"}} }, true},
+		{"dimension colliding with emitted attribute", func(c *Config) { c.Dimensions = []Dimension{{Name: "quantile"}} }, true},
+		{"renamed dimension", func(c *Config) {
+			c.Dimensions = []Dimension{{Name: "quantile"}}
+			c.OutputAttributes.Rename = map[string]string{"quantile": "upstream.quantile"}
+		}, false},
+		{"rename colliding with emitted attribute", func(c *Config) {
+			c.OutputAttributes.Rename = map[string]string{"http.route": "kind"}
+		}, true},
+		{"empty kind label", func(c *Config) { c.OutputAttributes.Kind = "" }, true},
+		{"empty quantile label", func(c *Config) { c.OutputAttributes.Quantile = "" }, true},
+		{"empty outcome label", func(c *Config) { c.OutputAttributes.Outcome = "" }, true},
+		{"empty status class label", func(c *Config) { c.OutputAttributes.StatusClass = "" }, true},
+		{"unknown temporality", func(c *Config) { c.AggregationTemporality = "banana" }, true},
+		{"histogram scale too high", func(c *Config) { c.HistogramScale = 21 }, true},
+		{"histogram scale too low", func(c *Config) { c.HistogramScale = -11 }, true},
+		{"zero series expiration", func(c *Config) { c.SeriesExpiration = 0 }, true},
+		{"histogram max size too small", func(c *Config) { c.HistogramMaxSize = 1 }, true},
+		{"route rules", func(c *Config) {
+			c.RouteNormalization.Rules = []RouteRule{{Pattern: `^/v1/customers/[^/]+$`, Template: "/v1/customers/{customer}"}}
+		}, false},
+		{"invalid route rule pattern", func(c *Config) { c.RouteNormalization.Rules = []RouteRule{{Pattern: "(", Template: "/"}} }, true},
+		{"route rule without template", func(c *Config) { c.RouteNormalization.Rules = []RouteRule{{Pattern: "^/"}} }, true},
+		{"empty url attribute", func(c *Config) { c.RouteNormalization.URLAttributes = []string{""} }, true},
+		{"integration rules", func(c *Config) {
+			c.IntegrationIDRules = []IntegrationRule{{Attribute: "server.address", Host: "*.stripe.com", IntegrationID: "stripe"}, {Attribute: "peer.service"}}
+		}, false},
+		{"integration rule without attribute", func(c *Config) {
+			c.IntegrationIDRules = []IntegrationRule{{Host: "*.stripe.com", IntegrationID: "stripe"}}
+		}, true},
+		{"integration rule host without id", func(c *Config) {
+			c.IntegrationIDRules = []IntegrationRule{{Attribute: "server.address", Host: "*.stripe.com"}}
+		}, true},
+		{"invalid integration rule host", func(c *Config) {
+			c.IntegrationIDRules = []IntegrationRule{{Attribute: "server.address", Host: "[stripe", IntegrationID: "stripe"}}
+		}, true},
+		{"integration overrides", func(c *Config) {
+			c.Integrations = map[string]IntegrationOverride{
+				"payments-*": {Percentiles: []float64{0.999}, DigestCapacity: 500},
+				"long-tail":  {SpanKinds: []string{"client"}},
+			}
+		}, false},
+		{"invalid integration pattern", func(c *Config) { c.Integrations = map[string]IntegrationOverride{"[payments": {}} }, true},
+		{"integration percentile out of range", func(c *Config) {
+			c.Integrations = map[string]IntegrationOverride{"payments": {Percentiles: []float64{1}}}
+		}, true},
+		{"negative integration digest capacity", func(c *Config) {
+			c.Integrations = map[string]IntegrationOverride{"payments": {DigestCapacity: -1}}
+		}, true},
+		{"integration digest capacity too high", func(c *Config) {
+			c.Integrations = map[string]IntegrationOverride{"payments": {DigestCapacity: 1_000_000}}
+		}, true},
+		{"unknown integration span kind", func(c *Config) {
+			c.Integrations = map[string]IntegrationOverride{"payments": {SpanKinds: []string{"banana"}}}
+		}, true},
+		{"sliding window", func(c *Config) { c.Window = WindowConfig{Size: 5 * time.Minute, Step: 30 * time.Second} }, false},
+		{"sliding window with interval step", func(c *Config) { c.Window = WindowConfig{Size: 5 * time.Minute} }, false},
+		{"negative window size", func(c *Config) { c.Window = WindowConfig{Size: -time.Minute} }, true},
+		{"window step over size", func(c *Config) { c.Window = WindowConfig{Size: time.Minute, Step: 2 * time.Minute} }, true},
+		{"window size not multiple of step", func(c *Config) { c.Window = WindowConfig{Size: 5 * time.Minute, Step: 2 * time.Minute} }, true},
+		{"window size not multiple of interval", func(c *Config) { c.Interval, c.Window = 2*time.Minute, WindowConfig{Size: 5 * time.Minute} }, true},
+		{"sliding window histogram", func(c *Config) {
+			c.Window = WindowConfig{Size: 5 * time.Minute}
+			c.Outputs = []string{outputGauge, outputExponentialHistogram}
+		}, true},
+		{"delta summary", func(c *Config) { c.Outputs = []string{outputSummary} }, true},
+		{"cumulative summary", func(c *Config) {
+			c.Outputs = []string{outputSummary}
+			c.AggregationTemporality = temporalityCumulative
+		}, false},
+		{"sliding window summary", func(c *Config) {
+			c.Window = WindowConfig{Size: 5 * time.Minute}
+			c.Outputs = []string{outputSummary}
+		}, true},
+		{"cumulative sliding window", func(c *Config) {
+			c.Window = WindowConfig{Size: 5 * time.Minute}
+			c.AggregationTemporality = "cumulative"
+		}, true},
+		{"series limits", func(c *Config) { c.MaxSeries, c.MaxSeriesPerIntegration = 1000, 100 }, false},
+		{"negative max series", func(c *Config) { c.MaxSeries = -1 }, true},
+		{"negative max series per integration", func(c *Config) { c.MaxSeriesPerIntegration = -1 }, true},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -74,7 +177,7 @@ func TestConnectorEmitsPercentilesPerSeries(t *testing.T) {
 	cfg.Percentiles = []float64{0.99}
 	cfg.MetricName = "custom.latency"
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
@@ -94,7 +197,7 @@ func TestConnectorEmitsPercentilesPerSeries(t *testing.T) {
 	if name := metricNameOf(sink.batches[0]); name != "custom.latency" {
 		t.Fatalf("expected configured metric name custom.latency, got %s", name)
 	}
-	dps := allDataPoints(sink.batches[0])
+	dps := latencyDataPoints(sink.batches[0])
 	// two series, one percentile each
 	if len(dps) != 2 {
 		t.Fatalf("expected 2 data points, got %d", len(dps))
@@ -104,14 +207,14 @@ func TestConnectorEmitsPercentilesPerSeries(t *testing.T) {
 		if q.AsString() != "0.99" {
 			t.Fatalf("expected quantile attribute 0.99, got %s", q.AsString())
 		}
-		if _, ok := dp.Attributes().Get(integrationIDAttribute); !ok {
-			t.Fatalf("expected %s attribute", integrationIDAttribute)
+		if _, ok := dp.Attributes().Get(defaultIntegrationIDAttribute); !ok {
+			t.Fatalf("expected %s attribute", defaultIntegrationIDAttribute)
 		}
-		if _, ok := dp.Attributes().Get(routeAttribute); !ok {
-			t.Fatalf("expected %s attribute", routeAttribute)
+		if _, ok := dp.Attributes().Get(defaultRouteAttribute); !ok {
+			t.Fatalf("expected %s attribute", defaultRouteAttribute)
 		}
-		if _, ok := dp.Attributes().Get(methodAttribute); !ok {
-			t.Fatalf("expected %s attribute", methodAttribute)
+		if _, ok := dp.Attributes().Get(defaultMethodAttribute); !ok {
+			t.Fatalf("expected %s attribute", defaultMethodAttribute)
 		}
 		if _, ok := dp.Attributes().Get(kindAttribute); !ok {
 			t.Fatalf("expected %s attribute", kindAttribute)
@@ -126,7 +229,7 @@ func TestConnectorSeparatesSeriesByMethod(t *testing.T) {
 	cfg := createDefaultConfig().(*Config)
 	cfg.Percentiles = []float64{0.99}
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
@@ -139,13 +242,13 @@ func TestConnectorSeparatesSeriesByMethod(t *testing.T) {
 		t.Fatalf("flush returned error: %v", err)
 	}
 
-	dps := allDataPoints(sink.batches[0])
+	dps := latencyDataPoints(sink.batches[0])
 	if len(dps) != 2 {
 		t.Fatalf("expected 2 data points (one per method), got %d", len(dps))
 	}
 	methods := map[string]bool{}
 	for _, dp := range dps {
-		m, _ := dp.Attributes().Get(methodAttribute)
+		m, _ := dp.Attributes().Get(defaultMethodAttribute)
 		methods[m.AsString()] = true
 	}
 	if !methods["GET"] || !methods["POST"] {
@@ -157,7 +260,7 @@ func TestConnectorMeasuresAllSpanKindsByDefault(t *testing.T) {
 	cfg := createDefaultConfig().(*Config)
 	cfg.Percentiles = []float64{0.99}
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	kinds := []ptrace.SpanKind{
@@ -178,7 +281,7 @@ func TestConnectorMeasuresAllSpanKindsByDefault(t *testing.T) {
 		t.Fatalf("flush returned error: %v", err)
 	}
 
-	dps := allDataPoints(sink.batches[0])
+	dps := latencyDataPoints(sink.batches[0])
 	if len(dps) != len(kinds) {
 		t.Fatalf("expected one series per span kind (%d), got %d", len(kinds), len(dps))
 	}
@@ -199,7 +302,7 @@ func TestConnectorFiltersDisabledSpanKinds(t *testing.T) {
 	cfg.Percentiles = []float64{0.99}
 	cfg.SpanKinds = []string{"server"}
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
@@ -212,7 +315,7 @@ func TestConnectorFiltersDisabledSpanKinds(t *testing.T) {
 		t.Fatalf("flush returned error: %v", err)
 	}
 
-	dps := allDataPoints(sink.batches[0])
+	dps := latencyDataPoints(sink.batches[0])
 	if len(dps) != 1 {
 		t.Fatalf("expected only the server series, got %d data points", len(dps))
 	}
@@ -225,7 +328,7 @@ func TestConnectorFiltersDisabledSpanKinds(t *testing.T) {
 func TestConnectorSkipsSpansWithoutAttributes(t *testing.T) {
 	cfg := createDefaultConfig().(*Config)
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	// span without method - ignored
@@ -256,10 +359,44 @@ func TestConnectorSkipsSpansWithoutAttributes(t *testing.T) {
 	}
 }
 
+func TestConnectorConsumesTracesConcurrently(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			td := ptrace.NewTraces()
+			for range 100 {
+				addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, time.Duration(worker+1)*time.Second)
+			}
+			if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+				t.Errorf("ConsumeTraces returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	dps := latencyDataPoints(sink.batches[0])
+	if len(dps) != 1 {
+		t.Fatalf("expected a single series, got %d data points", len(dps))
+	}
+	if median := dps[0].DoubleValue(); median < 4 || median > 5 {
+		t.Fatalf("expected median of all workers between 4s and 5s, got %v", median)
+	}
+}
+
 func TestConnectorFlushResetsAccumulators(t *testing.T) {
 	cfg := createDefaultConfig().(*Config)
 	sink := &metricsSink{}
-	conn := newLatenciesConnector(newConnectorSettings(), cfg, sink)
+	conn := newTestConnector(t, cfg, sink)
 
 	td := ptrace.NewTraces()
 	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
@@ -278,6 +415,1122 @@ func TestConnectorFlushResetsAccumulators(t *testing.T) {
 	}
 }
 
+func TestConnectorFlushesOnClockTicks(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Interval = time.Minute
+	sink := newChannelSink()
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	if err := conn.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+
+	manual.Advance(59 * time.Second)
+	select {
+	case <-sink.received:
+		t.Fatal("expected no metrics before interval passes")
+	default:
+	}
+
+	manual.Advance(time.Second)
+	md := sink.next(t)
+	ts := latencyDataPoints(md)[0].Timestamp().AsTime()
+	if want := clock.ParseTime("2025-02-22T12:05:05Z"); !ts.Equal(want) {
+		t.Fatalf("expected data point at tick time %s, got %s", want, ts)
+	}
+
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	manual.Advance(30 * time.Second)
+	if err := conn.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	md = sink.next(t)
+	ts = latencyDataPoints(md)[0].Timestamp().AsTime()
+	if want := clock.ParseTime("2025-02-22T12:05:35Z"); !ts.Equal(want) {
+		t.Fatalf("expected shutdown data point at clock time %s, got %s", want, ts)
+	}
+}
+
+func TestConnectorEmitsExponentialHistogramPerSeries(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Outputs = []string{outputExponentialHistogram}
+	cfg.HistogramScale = 2
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 102*time.Millisecond)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 1900*time.Millisecond)
+	addServerSpan(td, "integration-b", "/v1/users", "GET", 0, 50*time.Millisecond)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	if dps := latencyDataPoints(sink.batches[0]); len(dps) != 0 {
+		t.Fatalf("expected no percentile gauges, got %d data points", len(dps))
+	}
+	histogram := metricByName(t, sink.batches[0], cfg.HistogramMetricName).ExponentialHistogram()
+	if histogram.AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+		t.Fatalf("expected delta histogram, got %s", histogram.AggregationTemporality())
+	}
+	if histogram.DataPoints().Len() != 2 {
+		t.Fatalf("expected histogram per series, got %d data points", histogram.DataPoints().Len())
+	}
+	for i := 0; i < histogram.DataPoints().Len(); i++ {
+		dp := histogram.DataPoints().At(i)
+		if id, _ := dp.Attributes().Get(defaultIntegrationIDAttribute); id.AsString() != "integration-a" {
+			continue
+		}
+		if dp.Scale() != 2 || dp.Count() != 3 || dp.ZeroCount() != 0 {
+			t.Fatalf("expected 3 latencies in scale 2 histogram, got count %d scale %d", dp.Count(), dp.Scale())
+		}
+		if math.Abs(dp.Sum()-2.102) > 1e-9 {
+			t.Fatalf("expected sum 2.102, got %v", dp.Sum())
+		}
+		if dp.Min() != 0.1 || dp.Max() != 1.9 {
+			t.Fatalf("expected min 0.1 and max 1.9, got %v and %v", dp.Min(), dp.Max())
+		}
+		// 0.1s and 0.102s share bucket [2^-3.5, 2^-3.25), 1.9s is 17 buckets above
+		counts := dp.Positive().BucketCounts().AsRaw()
+		if dp.Positive().Offset() != -14 || len(counts) != 18 || counts[0] != 2 || counts[17] != 1 {
+			t.Fatalf("unexpected buckets at offset %d: %v", dp.Positive().Offset(), counts)
+		}
+	}
+}
+
+func TestConnectorDownscalesWideHistogramsToMaxSize(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Outputs = []string{outputExponentialHistogram}
+	cfg.HistogramScale = 20
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, time.Millisecond)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 30*time.Second)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	dp := metricByName(t, sink.batches[0], cfg.HistogramMetricName).ExponentialHistogram().DataPoints().At(0)
+	if counts := dp.Positive().BucketCounts().Len(); counts > defaultHistogramMaxSize {
+		t.Fatalf("expected at most %d buckets, got %d", defaultHistogramMaxSize, counts)
+	}
+	if dp.Scale() >= 20 || dp.Count() != 2 {
+		t.Fatalf("expected 2 latencies in downscaled histogram, got count %d scale %d", dp.Count(), dp.Scale())
+	}
+}
+
+func TestConnectorEmitsSummaryWithGauges(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Outputs = []string{outputGauge, outputSummary}
+	cfg.AggregationTemporality = temporalityCumulative
+	cfg.Percentiles = []float64{0.5, 0.99}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	for _, latency := range []time.Duration{100, 200, 300, 400} {
+		addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, latency*time.Millisecond)
+	}
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	if dps := latencyDataPoints(sink.batches[0]); len(dps) != 2 {
+		t.Fatalf("expected gauge per percentile, got %d data points", len(dps))
+	}
+	summary := metricByName(t, sink.batches[0], cfg.SummaryMetricName).Summary().DataPoints()
+	if summary.Len() != 1 {
+		t.Fatalf("expected summary of single series, got %d data points", summary.Len())
+	}
+	dp := summary.At(0)
+	if dp.Count() != 4 || math.Abs(dp.Sum()-1) > 1e-9 {
+		t.Fatalf("expected count 4 and sum 1s, got %d and %v", dp.Count(), dp.Sum())
+	}
+	if dp.QuantileValues().Len() != 2 {
+		t.Fatalf("expected 2 quantile values, got %d", dp.QuantileValues().Len())
+	}
+	median := dp.QuantileValues().At(0)
+	if median.Quantile() != 0.5 || median.Value() < 0.2 || median.Value() > 0.3 {
+		t.Fatalf("expected median between 0.2s and 0.3s, got %v at %v", median.Value(), median.Quantile())
+	}
+	if route, _ := dp.Attributes().Get(defaultRouteAttribute); route.AsString() != "/v1/orders" {
+		t.Fatalf("expected summary of /v1/orders, got %s", route.AsString())
+	}
+}
+
+func TestConnectorDeltaDataPointsStartAtPreviousEmission(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Outputs = []string{outputGauge, outputExponentialHistogram}
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	manual.Advance(5 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	manual.Advance(2 * time.Second)
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	manual.Advance(3 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	expectDataPointTimes(t, sink.batches[0], "2025-02-22T12:04:05Z", "2025-02-22T12:04:10Z")
+	expectDataPointTimes(t, sink.batches[1], "2025-02-22T12:04:10Z", "2025-02-22T12:04:15Z")
+	requests := metricByName(t, sink.batches[1], cfg.RequestsMetricName).Sum()
+	if requests.AggregationTemporality() != pmetric.AggregationTemporalityDelta || requests.DataPoints().At(0).IntValue() != 1 {
+		t.Fatalf("expected delta count of second interval only")
+	}
+}
+
+func TestConnectorCumulativeTemporalityKeepsAccumulating(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.AggregationTemporality = temporalityCumulative
+	cfg.Outputs = []string{outputExponentialHistogram}
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	manual.Advance(10 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	consumeServerSpan(t, conn, 200*time.Millisecond)
+	manual.Advance(10 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	// series without new spans is still emitted
+	manual.Advance(10 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	if len(sink.batches) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(sink.batches))
+	}
+	expectDataPointTimes(t, sink.batches[1], "2025-02-22T12:04:05Z", "2025-02-22T12:04:25Z")
+	expectDataPointTimes(t, sink.batches[2], "2025-02-22T12:04:05Z", "2025-02-22T12:04:35Z")
+	for i, wantCount := range []int64{1, 2, 2} {
+		requests := metricByName(t, sink.batches[i], cfg.RequestsMetricName).Sum()
+		if requests.AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+			t.Fatalf("expected cumulative requests, got %s", requests.AggregationTemporality())
+		}
+		if count := requests.DataPoints().At(0).IntValue(); count != wantCount {
+			t.Fatalf("expected cumulative count %d in batch %d, got %d", wantCount, i, count)
+		}
+		histogram := metricByName(t, sink.batches[i], cfg.HistogramMetricName).ExponentialHistogram()
+		if histogram.AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+			t.Fatalf("expected cumulative histogram, got %s", histogram.AggregationTemporality())
+		}
+		if count := histogram.DataPoints().At(0).Count(); int64(count) != wantCount {
+			t.Fatalf("expected cumulative histogram count %d in batch %d, got %d", wantCount, i, count)
+		}
+	}
+}
+
+func consumeServerSpan(t *testing.T, conn *latenciesConnector, duration time.Duration) {
+	t.Helper()
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, duration)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+}
+
+// expectDataPointTimes checks start and timestamp of every data point.
+func expectDataPointTimes(t *testing.T, md pmetric.Metrics, start, end string) {
+	t.Helper()
+	wantStart := pcommon.NewTimestampFromTime(clock.ParseTime(start))
+	wantEnd := pcommon.NewTimestampFromTime(clock.ParseTime(end))
+	check := func(name string, gotStart, gotEnd pcommon.Timestamp) {
+		if gotStart != wantStart || gotEnd != wantEnd {
+			t.Fatalf("expected %s data point from %s to %s, got %s to %s", name, wantStart, wantEnd, gotStart, gotEnd)
+		}
+	}
+	for _, m := range allMetrics(md) {
+		switch m.Type() {
+		case pmetric.MetricTypeGauge:
+			for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+				dp := m.Gauge().DataPoints().At(i)
+				check(m.Name(), dp.StartTimestamp(), dp.Timestamp())
+			}
+		case pmetric.MetricTypeSum:
+			for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+				dp := m.Sum().DataPoints().At(i)
+				check(m.Name(), dp.StartTimestamp(), dp.Timestamp())
+			}
+		case pmetric.MetricTypeExponentialHistogram:
+			for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
+				dp := m.ExponentialHistogram().DataPoints().At(i)
+				check(m.Name(), dp.StartTimestamp(), dp.Timestamp())
+			}
+		case pmetric.MetricTypeSummary:
+			for i := 0; i < m.Summary().DataPoints().Len(); i++ {
+				dp := m.Summary().DataPoints().At(i)
+				check(m.Name(), dp.StartTimestamp(), dp.Timestamp())
+			}
+		default:
+			t.Fatalf("unexpected metric type %s", m.Type())
+		}
+	}
+}
+
+func TestConnectorDeltaSeriesStartAtFirstSpanAfterExpiration(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.SeriesExpiration = time.Minute
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	manual.Advance(5 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	manual.Advance(time.Minute)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	manual.Advance(5 * time.Second)
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	manual.Advance(5 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	expectDataPointTimes(t, sink.batches[1], "2025-02-22T12:05:15Z", "2025-02-22T12:05:20Z")
+	if len(conn.lastEmitted) != 1 {
+		t.Fatalf("expected emission time of single series, got %d", len(conn.lastEmitted))
+	}
+}
+
+func TestConnectorExpiresStaleCumulativeSeries(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.AggregationTemporality = temporalityCumulative
+	cfg.SeriesExpiration = time.Minute
+	cfg.MaxSeries = 1
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:04:05Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	consumeServerSpan(t, conn, 100*time.Millisecond)
+	for range 7 {
+		manual.Advance(10 * time.Second)
+		if err := conn.flush(context.Background(), manual.Now()); err != nil {
+			t.Fatalf("flush returned error: %v", err)
+		}
+	}
+	if len(sink.batches) != 6 || conn.series.Len() != 0 {
+		t.Fatalf("expected series emitted until it expires, got %d batches and %d series", len(sink.batches), conn.series.Len())
+	}
+
+	// expired series no longer counts towards series limits
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-b", "/v1/users", "GET", 0, time.Second)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	manual.Advance(10 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	dp := latencyDataPoints(sink.batches[6])[0]
+	if integrationID, _ := dp.Attributes().Get(cfg.IntegrationIDAttribute); integrationID.Str() != "integration-b" {
+		t.Fatalf("expected series of integration-b, got %v", dp.Attributes().AsRaw())
+	}
+	expectDataPointTimes(t, sink.batches[6], "2025-02-22T12:05:15Z", "2025-02-22T12:05:25Z")
+}
+
+func TestDimensionValuesRoundTrip(t *testing.T) {
+	fallback := "unknown"
+	dimensions := []Dimension{
+		{Name: "server.address"},
+		{Name: "peer.service"},
+		{Name: "region", Default: &fallback},
+		{Name: "tenant"},
+		{Name: "zone"},
+	}
+	spanAttrs := pcommon.NewMap()
+	spanAttrs.PutStr("server.address", "api.stripe.com:443")
+	spanAttrs.PutStr("tenant", "")
+	resourceAttrs := pcommon.NewMap()
+	resourceAttrs.PutStr("zone", "-3:x")
+	resourceAttrs.PutStr("server.address", "ignored")
+
+	attrs := pcommon.NewMap()
+	labels := (&Config{Dimensions: dimensions}).outputLabels().dimensions
+	putDimensionAttributes(attrs, labels, dimensionValues(dimensions, spanAttrs, resourceAttrs))
+
+	want := map[string]any{
+		"server.address": "api.stripe.com:443",
+		"region":         "unknown",
+		"tenant":         "",
+		"zone":           "-3:x",
+	}
+	if got := attrs.AsRaw(); !maps.Equal(got, want) {
+		t.Fatalf("expected attributes %v, got %v", want, got)
+	}
+}
+
+func TestConnectorPartitionsSeriesByDimensions(t *testing.T) {
+	fallback := "unknown"
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.99}
+	cfg.Dimensions = []Dimension{{Name: "server.address"}, {Name: "region", Default: &fallback}}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	for _, address := range []string{"api.stripe.com", "api.stripe.com", "api.adyen.com"} {
+		addClientSpan(td, "payments", "/v1/charges", "POST", 0, 100*time.Millisecond)
+		lastSpan(td).Attributes().PutStr("server.address", address)
+	}
+	addClientSpan(td, "payments", "/v1/charges", "POST", 0, 100*time.Millisecond)
+	td.ResourceSpans().At(td.ResourceSpans().Len()-1).Resource().Attributes().PutStr("region", "eu-west-1")
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, dp := range latencyDataPoints(sink.batches[0]) {
+		address, hasAddress := dp.Attributes().Get("server.address")
+		region, _ := dp.Attributes().Get("region")
+		if !hasAddress {
+			got["none/"+region.AsString()] = true
+			continue
+		}
+		got[address.AsString()+"/"+region.AsString()] = true
+	}
+	want := map[string]bool{
+		"api.stripe.com/unknown": true,
+		"api.adyen.com/unknown":  true,
+		"none/eu-west-1":         true,
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected series %v, got %v", want, got)
+	}
+}
+
+func TestConnectorCountsSpansWithoutRouteUnderDefault(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.99}
+	cfg.RouteDefault = "unknown_route"
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	span := appendSpan(td)
+	span.SetKind(ptrace.SpanKindClient)
+	span.Attributes().PutStr(cfg.IntegrationIDAttribute, "integration-a")
+	span.Attributes().PutStr(cfg.MethodAttribute, "GET")
+	// span without method is still dropped
+	span2 := appendSpan(td)
+	span2.SetKind(ptrace.SpanKindClient)
+	span2.Attributes().PutStr(cfg.IntegrationIDAttribute, "integration-a")
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	dps := latencyDataPoints(sink.batches[0])
+	if len(dps) != 1 {
+		t.Fatalf("expected single series, got %d data points", len(dps))
+	}
+	if route, _ := dps[0].Attributes().Get(defaultRouteAttribute); route.AsString() != "unknown_route" {
+		t.Fatalf("expected default route, got %s", route.AsString())
+	}
+}
+
+func TestConnectorEmitsConfiguredAttributeKeys(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.99}
+	cfg.IntegrationIDAttribute = "peer.service"
+	cfg.RouteAttribute = "url.template"
+	cfg.MethodAttribute = "http.method"
+	cfg.Dimensions = []Dimension{{Name: "server.address"}}
+	cfg.OutputAttributes = OutputAttributes{
+		Rename: map[string]string{
+			"peer.service":   "integration",
+			"server.address": "host",
+		},
+		Kind:        "span.kind",
+		Quantile:    "p",
+		Outcome:     "result",
+		StatusClass: "status",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	span := appendSpan(td)
+	span.SetKind(ptrace.SpanKindClient)
+	span.Attributes().PutStr("peer.service", "stripe")
+	span.Attributes().PutStr("url.template", "/v1/charges/{id}")
+	span.Attributes().PutStr("http.method", "GET")
+	span.Attributes().PutStr("server.address", "api.stripe.com")
+	span.Attributes().PutInt(cfg.StatusCodeAttribute, 200)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	wantSeries := map[string]any{
+		"integration":  "stripe",
+		"url.template": "/v1/charges/{id}",
+		"http.method":  "GET",
+		"span.kind":    "client",
+		"host":         "api.stripe.com",
+	}
+	gauge := latencyDataPoints(sink.batches[0])[0].Attributes().AsRaw()
+	if !maps.Equal(gauge, withAttributes(wantSeries, map[string]any{"p": "0.99"})) {
+		t.Fatalf("unexpected gauge attributes %v", gauge)
+	}
+	requests := metricByName(t, sink.batches[0], cfg.RequestsMetricName).Sum().DataPoints().At(0).Attributes().AsRaw()
+	if !maps.Equal(requests, withAttributes(wantSeries, map[string]any{"result": "ok", "status": "2xx"})) {
+		t.Fatalf("unexpected requests attributes %v", requests)
+	}
+}
+
+func withAttributes(attrs map[string]any, extra map[string]any) map[string]any {
+	merged := maps.Clone(attrs)
+	maps.Copy(merged, extra)
+	return merged
+}
+
+func TestConnectorRoutesSpansOverMaxSeriesToOverflowSeries(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	cfg.MaxSeries = 2
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	// series of single batch are admitted in order of their shards, so the
+	// admitted series are committed first
+	admitted := ptrace.NewTraces()
+	addServerSpan(admitted, "integration-a", "/v1/orders", "GET", 0, 1*time.Second)
+	addServerSpan(admitted, "integration-b", "/v1/orders", "GET", 0, 2*time.Second)
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders/1", "GET", 0, 3*time.Second)
+	addServerSpan(td, "integration-a", "/v1/orders/2", "GET", 0, 3*time.Second)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 1*time.Second)
+	for _, td := range []ptrace.Traces{admitted, td} {
+		if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("ConsumeTraces returned error: %v", err)
+		}
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	dps := latencyDataPoints(sink.batches[0])
+	if len(dps) != 3 {
+		t.Fatalf("expected 2 series and overflow series, got %d data points", len(dps))
+	}
+	overflowed := 0
+	for _, dp := range dps {
+		if _, ok := dp.Attributes().Get(overflowAttribute); !ok {
+			continue
+		}
+		overflowed++
+		want := map[string]any{overflowAttribute: true, "quantile": "0.5"}
+		if got := dp.Attributes().AsRaw(); !maps.Equal(got, want) {
+			t.Fatalf("expected overflow attributes %v, got %v", want, got)
+		}
+		if dp.DoubleValue() != 3 {
+			t.Fatalf("expected overflow median 3s, got %v", dp.DoubleValue())
+		}
+	}
+	if overflowed != 1 {
+		t.Fatalf("expected single overflow series, got %d", overflowed)
+	}
+
+	// limits are reset with accumulators of delta temporality
+	consumeServerSpan(t, conn, time.Second)
+	td = ptrace.NewTraces()
+	addServerSpan(td, "integration-c", "/v1/orders", "GET", 0, time.Second)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(1, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	for _, dp := range latencyDataPoints(sink.batches[1]) {
+		if _, ok := dp.Attributes().Get(overflowAttribute); ok {
+			t.Fatalf("expected no overflow after reset, got %v", dp.Attributes().AsRaw())
+		}
+	}
+}
+
+func TestConnectorLimitsSeriesPerIntegration(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	cfg.MaxSeriesPerIntegration = 1
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	// series of single batch are admitted in order of their shards, so the
+	// admitted series of integration-a is committed first
+	first := ptrace.NewTraces()
+	addServerSpan(first, "integration-a", "/v1/orders", "GET", 0, time.Second)
+	second := ptrace.NewTraces()
+	addServerSpan(second, "integration-a", "/v1/orders/1", "GET", 0, time.Second)
+	addServerSpan(second, "integration-b", "/v1/orders/1", "GET", 0, time.Second)
+	for _, td := range []ptrace.Traces{first, second} {
+		if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("ConsumeTraces returned error: %v", err)
+		}
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	integrations := map[string]bool{}
+	for _, dp := range latencyDataPoints(sink.batches[0]) {
+		if _, ok := dp.Attributes().Get(overflowAttribute); ok {
+			integrations["overflow"] = true
+			continue
+		}
+		integrationID, _ := dp.Attributes().Get("x-integration-id")
+		route, _ := dp.Attributes().Get("http.route")
+		integrations[integrationID.Str()+route.Str()] = true
+	}
+	want := map[string]bool{"integration-a/v1/orders": true, "integration-b/v1/orders/1": true, "overflow": true}
+	if !maps.Equal(integrations, want) {
+		t.Fatalf("expected series %v, got %v", want, integrations)
+	}
+}
+
+func TestConnectorReportsDroppedAndOverflowedSpans(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.MaxSeries = 1
+	reader := sdkmetric.NewManualReader()
+	set := newConnectorSettings()
+	set.TelemetrySettings.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	conn, err := newLatenciesConnector(set, cfg, &metricsSink{})
+	if err != nil {
+		t.Fatalf("newLatenciesConnector returned error: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, time.Second)
+	addServerSpan(td, "integration-b", "/v1/orders", "GET", 0, time.Second)
+	addServerSpan(td, "integration-c", "/v1/orders", "GET", 0, time.Second)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", time.Second, -time.Millisecond)
+	appendSpan(td).SetKind(ptrace.SpanKindServer)
+	addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, time.Second)
+	lastSpan(td).Attributes().Remove(cfg.MethodAttribute)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect returned error: %v", err)
+	}
+	got := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				reason, _ := dp.Attributes.Value("reason")
+				got[m.Name+" "+reason.AsString()] += dp.Value
+			}
+		}
+	}
+	want := map[string]int64{
+		"otelcol_connector_latencies_spans_overflowed ":                    2,
+		"otelcol_connector_latencies_spans_dropped missing_attribute":      1,
+		"otelcol_connector_latencies_spans_dropped negative_duration":      1,
+		"otelcol_connector_latencies_spans_dropped unresolved_integration": 1,
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected span counts %v, got %v", want, got)
+	}
+}
+
+func TestRouteNormalizerNormalizesURLs(t *testing.T) {
+	normalizer, err := newRouteNormalizer(RouteNormalization{
+		URLAttributes: []string{"url.path", "url.full"},
+		Rules: []RouteRule{
+			{Pattern: `^/v1/customers/[^/]+/(\w+)$`, Template: "/v1/customers/{customer}/$1"},
+		},
+		DetectIDs: true,
+	})
+	if err != nil {
+		t.Fatalf("newRouteNormalizer returned error: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		attrs map[string]any
+		want  string
+	}{
+		{"numeric id", map[string]any{"url.path": "/v1/orders/123"}, "/v1/orders/{id}"},
+		{"uuid", map[string]any{"url.path": "/v1/orders/3f2b8c1e-9d4a-4e8b-a1c2-7f6e5d4c3b2a/items"}, "/v1/orders/{id}/items"},
+		{"hash", map[string]any{"url.path": "/v1/blobs/9e107d9d372bb6826bd81d3542a419d6"}, "/v1/blobs/{id}"},
+		{"short hex word kept", map[string]any{"url.path": "/v1/feed/cafe"}, "/v1/feed/cafe"},
+		{"full url without query", map[string]any{"url.full": "https://api.example.com/v1/orders/42?expand=items"}, "/v1/orders/{id}"},
+		{"full url without path", map[string]any{"url.full": "https://api.example.com"}, "/"},
+		{"path preferred over full url", map[string]any{"url.path": "/v1/a", "url.full": "https://api.example.com/v1/b"}, "/v1/a"},
+		{"rule wins over id detection", map[string]any{"url.path": "/v1/customers/cus_9fQ2/invoices"}, "/v1/customers/{customer}/invoices"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attrs := pcommon.NewMap()
+			if err := attrs.FromRaw(tc.attrs); err != nil {
+				t.Fatalf("FromRaw returned error: %v", err)
+			}
+			got, ok := normalizer.route(attrs)
+			if !ok || got != tc.want {
+				t.Fatalf("expected route %q, got %q (found %v)", tc.want, got, ok)
+			}
+		})
+	}
+
+	if _, ok := normalizer.route(pcommon.NewMap()); ok {
+		t.Fatalf("expected no route without url attributes")
+	}
+}
+
+func TestConnectorDerivesRouteOfClientSpansFromURL(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	for _, orderID := range []string{"1001", "1002", "1003"} {
+		span := appendSpan(td)
+		span.SetKind(ptrace.SpanKindClient)
+		span.Attributes().PutStr(cfg.IntegrationIDAttribute, "integration-a")
+		span.Attributes().PutStr(cfg.MethodAttribute, "GET")
+		span.Attributes().PutStr("url.full", "https://api.example.com/v1/orders/"+orderID)
+		span.SetEndTimestamp(pcommon.Timestamp(time.Second))
+	}
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	dps := latencyDataPoints(sink.batches[0])
+	if len(dps) != 1 {
+		t.Fatalf("expected single series of all orders, got %d data points", len(dps))
+	}
+	if route, _ := dps[0].Attributes().Get(cfg.RouteAttribute); route.Str() != "/v1/orders/{id}" {
+		t.Fatalf("expected normalized route, got %q", route.Str())
+	}
+}
+
+func TestIntegrationResolverEvaluatesRulesInOrder(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.IntegrationIDRules = []IntegrationRule{
+		{Attribute: "x-integration-id"},
+		{Attribute: "server.address", Host: "*.stripe.com", IntegrationID: "stripe"},
+		{Attribute: "url.full", Host: "api.github.com", IntegrationID: "github"},
+		{Attribute: "peer.service"},
+		{Attribute: "url.full"},
+		{Attribute: "upstream", ExtractHost: true},
+	}
+	resolver := newIntegrationResolver(cfg)
+
+	cases := []struct {
+		name          string
+		spanAttrs     map[string]any
+		resourceAttrs map[string]any
+		want          string
+	}{
+		{"explicit attribute", map[string]any{"x-integration-id": "billing", "server.address": "api.stripe.com"}, nil, "billing"},
+		{"host pattern", map[string]any{"server.address": "API.Stripe.com", "peer.service": "payments"}, nil, "stripe"},
+		{"host of url", map[string]any{"url.full": "https://api.github.com:443/repos?page=2"}, nil, "github"},
+		{"unmatched host falls through", map[string]any{"server.address": "stripe.example.com", "peer.service": "payments"}, nil, "payments"},
+		{"resource attribute", nil, map[string]any{"peer.service": "ledger"}, "ledger"},
+		{"url host as id", map[string]any{"url.full": "https://hooks.slack.com/services/T000"}, nil, "hooks.slack.com"},
+		{"id with colon", map[string]any{"x-integration-id": "tenant:payments"}, nil, "tenant:payments"},
+		{"id in url form", map[string]any{"x-integration-id": "urn://payments:eu/v2"}, nil, "urn://payments:eu/v2"},
+		{"service not url", map[string]any{"peer.service": "ledger://eu"}, nil, "ledger://eu"},
+		{"host of url requested by rule", map[string]any{"upstream": "https://ledger.internal/v1"}, nil, "ledger.internal"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			spanAttrs, resourceAttrs := pcommon.NewMap(), pcommon.NewMap()
+			if err := spanAttrs.FromRaw(tc.spanAttrs); err != nil {
+				t.Fatalf("FromRaw returned error: %v", err)
+			}
+			if err := resourceAttrs.FromRaw(tc.resourceAttrs); err != nil {
+				t.Fatalf("FromRaw returned error: %v", err)
+			}
+			got, ok := resolver.resolve(spanAttrs, resourceAttrs)
+			if !ok || got != tc.want {
+				t.Fatalf("expected integration %q, got %q (resolved %v)", tc.want, got, ok)
+			}
+		})
+	}
+
+	if _, ok := resolver.resolve(pcommon.NewMap(), pcommon.NewMap()); ok {
+		t.Fatalf("expected span without attributes to stay unresolved")
+	}
+}
+
+func TestConnectorResolvesIntegrationOfClientSpans(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	cfg.IntegrationIDRules = []IntegrationRule{
+		{Attribute: "server.address", Host: "*.stripe.com", IntegrationID: "stripe"},
+	}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	for _, host := range []string{"api.stripe.com", "files.stripe.com", "api.example.com"} {
+		addClientSpan(td, "", "/v1/charges", "POST", 0, time.Second)
+		lastSpan(td).Attributes().Remove(cfg.IntegrationIDAttribute)
+		lastSpan(td).Attributes().PutStr("server.address", host)
+	}
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	dps := latencyDataPoints(sink.batches[0])
+	if len(dps) != 1 {
+		t.Fatalf("expected single stripe series, got %d data points", len(dps))
+	}
+	if integrationID, _ := dps[0].Attributes().Get(cfg.IntegrationIDAttribute); integrationID.Str() != "stripe" {
+		t.Fatalf("expected integration stripe, got %q", integrationID.Str())
+	}
+	requests := metricByName(t, sink.batches[0], cfg.RequestsMetricName).Sum().DataPoints()
+	if requests.Len() != 1 || requests.At(0).IntValue() != 2 {
+		t.Fatalf("expected 2 requests of stripe, got %d data points", requests.Len())
+	}
+}
+
+func TestIntegrationOverridesPreferExactIDAndLongerPatterns(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Integrations = map[string]IntegrationOverride{
+		"payments-*":        {Percentiles: []float64{0.99}},
+		"payments-stripe-*": {Percentiles: []float64{0.999}, DigestCapacity: 500},
+		"payments-adyen":    {SpanKinds: []string{"client"}},
+	}
+	overrides := newIntegrationOverrides(cfg)
+
+	stripe := overrides.settingsOf("payments-stripe-eu")
+	if !slices.Equal(stripe.percentiles, []float64{0.999}) || stripe.digestCapacity != 500 {
+		t.Fatalf("expected longest pattern to win, got %+v", stripe)
+	}
+	paypal := overrides.settingsOf("payments-paypal")
+	if !slices.Equal(paypal.percentiles, []float64{0.99}) || paypal.digestCapacity != tdigestCapacity {
+		t.Fatalf("expected payments pattern with default capacity, got %+v", paypal)
+	}
+	adyen := overrides.settingsOf("payments-adyen")
+	if !slices.Equal(adyen.percentiles, cfg.Percentiles) || adyen.enabledKinds[kindServer] || !adyen.enabledKinds[kindClient] {
+		t.Fatalf("expected exact id to win with inherited percentiles, got %+v", adyen)
+	}
+	if other := overrides.settingsOf("crm"); other != overrides.defaults {
+		t.Fatalf("expected defaults of integration without override, got %+v", other)
+	}
+}
+
+func TestIntegrationOverridesCacheSettingsMatchedByPatterns(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Integrations = map[string]IntegrationOverride{
+		"payments-*": {Percentiles: []float64{0.99}},
+	}
+	overrides := newIntegrationOverrides(cfg)
+
+	paypal := overrides.settingsOf("payments-paypal")
+	if cached := overrides.settingsOf("payments-paypal"); cached != paypal {
+		t.Fatalf("expected cached settings %+v, got %+v", paypal, cached)
+	}
+	if other := overrides.settingsOf("crm"); other != overrides.defaults {
+		t.Fatalf("expected defaults of unmatched integration, got %+v", other)
+	}
+	if count := overrides.resolvedCount.Load(); count != 2 {
+		t.Fatalf("expected 2 cached integrations, got %d", count)
+	}
+
+	overrides.resolvedCount.Store(maxResolvedIntegrations)
+	if settings := overrides.settingsOf("payments-adyen"); settings != paypal {
+		t.Fatalf("expected payments pattern over cache limit, got %+v", settings)
+	}
+	if _, found := overrides.resolved.Load("payments-adyen"); found {
+		t.Fatalf("expected no cached settings over cache limit")
+	}
+}
+
+func TestIntegrationOverridesKeepDefaultsOfOverflowSeries(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Integrations = map[string]IntegrationOverride{
+		"*": {Percentiles: []float64{0.999}, DigestCapacity: 1000},
+	}
+	overrides := newIntegrationOverrides(cfg)
+
+	if settings := overrides.seriesSettings(overflowKey); settings != overrides.defaults {
+		t.Fatalf("expected defaults of overflow series, got %+v", settings)
+	}
+	if settings := overrides.seriesSettings(seriesKey{integrationID: "crm"}); settings.digestCapacity != 1000 {
+		t.Fatalf("expected override of matching integration, got %+v", settings)
+	}
+}
+
+func TestConnectorAppliesIntegrationOverrides(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.5}
+	cfg.SpanKinds = []string{kindServer}
+	cfg.Integrations = map[string]IntegrationOverride{
+		"payments-*": {Percentiles: []float64{0.5, 0.999}, DigestCapacity: 1000, SpanKinds: []string{kindClient}},
+	}
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	addServerSpan(td, "crm", "/v1/contacts", "GET", 0, time.Second)
+	addClientSpan(td, "crm", "/v1/contacts", "GET", 0, time.Second)
+	addServerSpan(td, "payments-stripe", "/v1/charges", "POST", 0, time.Second)
+	addClientSpan(td, "payments-stripe", "/v1/charges", "POST", 0, 2*time.Second)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, dp := range latencyDataPoints(sink.batches[0]) {
+		integrationID, _ := dp.Attributes().Get(cfg.IntegrationIDAttribute)
+		kind, _ := dp.Attributes().Get("kind")
+		quantile, _ := dp.Attributes().Get("quantile")
+		got[integrationID.Str()+" "+kind.Str()+" "+quantile.Str()] = strconv.FormatFloat(dp.DoubleValue(), 'g', -1, 64)
+	}
+	want := map[string]string{
+		"crm server 0.5":               "1",
+		"payments-stripe client 0.5":   "2",
+		"payments-stripe client 0.999": "2",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected data points %v, got %v", want, got)
+	}
+}
+
+func TestConnectorEmitsPercentilesOverSlidingWindow(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Percentiles = []float64{0.99}
+	cfg.Window = WindowConfig{Size: 30 * time.Second, Step: 10 * time.Second}
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:00:00Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	manual.Advance(time.Second)
+	consumeServerSpan(t, conn, 3*time.Second)
+	manual.Advance(10 * time.Second)
+	consumeServerSpan(t, conn, time.Second)
+	// emission ticks late, window still ends at step boundary
+	manual.Advance(14 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	manual.Advance(15 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	manual.Advance(30 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	if len(sink.batches) != 2 {
+		t.Fatalf("expected emissions of 2 windows with spans, got %d", len(sink.batches))
+	}
+	expectDataPointTimes(t, sink.batches[0], "2025-02-22T11:59:50Z", "2025-02-22T12:00:20Z")
+	if p99 := latencyDataPoints(sink.batches[0])[0].DoubleValue(); p99 != 3 {
+		t.Fatalf("expected p99 of both spans 3s, got %v", p99)
+	}
+	expectDataPointTimes(t, sink.batches[1], "2025-02-22T12:00:10Z", "2025-02-22T12:00:40Z")
+	if p99 := latencyDataPoints(sink.batches[1])[0].DoubleValue(); p99 != 1 {
+		t.Fatalf("expected p99 of span left in window 1s, got %v", p99)
+	}
+	requests := metricByName(t, sink.batches[1], cfg.RequestsMetricName)
+	if requests.Type() != pmetric.MetricTypeGauge {
+		t.Fatalf("expected request count of window as gauge, got %s", requests.Type())
+	}
+	if count := requests.Gauge().DataPoints().At(0).IntValue(); count != 1 {
+		t.Fatalf("expected single request in window, got %d", count)
+	}
+	if conn.windows.Len() != 0 {
+		t.Fatalf("expected series without spans in window evicted, got %d", conn.windows.Len())
+	}
+}
+
+func TestConnectorLimitsSeriesWithValuesOnlyInStepInProgress(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Window = WindowConfig{Size: 30 * time.Second, Step: 10 * time.Second}
+	cfg.MaxSeries = 1
+	sink := &metricsSink{}
+	manual := clock.NewManualClock(clock.ParseTime("2025-02-22T12:00:01Z"))
+	conn := newTestConnector(t, cfg, sink)
+	conn.clock = manual
+
+	consumeServerSpan(t, conn, time.Second)
+	// step of the span is still in progress, no completed window to emit
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	td := ptrace.NewTraces()
+	addServerSpan(td, "integration-b", "/v1/users", "GET", 0, time.Second)
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	manual.Advance(10 * time.Second)
+	if err := conn.flush(context.Background(), manual.Now()); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	overflowed := 0
+	for _, dp := range latencyDataPoints(sink.batches[0]) {
+		if _, ok := dp.Attributes().Get(overflowAttribute); ok {
+			overflowed++
+		}
+	}
+	if overflowed == 0 {
+		t.Fatalf("expected span of second series in overflow series")
+	}
+}
+
+func TestSpanStatusOf(t *testing.T) {
+	cases := []struct {
+		name       string
+		kind       ptrace.SpanKind
+		statusCode ptrace.StatusCode
+		attrs      map[string]any
+		want       spanStatus
+	}{
+		{"no status code", ptrace.SpanKindServer, ptrace.StatusCodeUnset, nil, spanStatus{outcome: "ok"}},
+		{"success", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 200}, spanStatus{"ok", "2xx"}},
+		{"server not found", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 404}, spanStatus{"ok", "4xx"}},
+		{"client not found", ptrace.SpanKindClient, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 404}, spanStatus{"error", "4xx"}},
+		{"server error", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 503}, spanStatus{"error", "5xx"}},
+		{"status code as string", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": "503"}, spanStatus{"error", "5xx"}},
+		{"error status", ptrace.SpanKindInternal, ptrace.StatusCodeError, nil, spanStatus{outcome: "error"}},
+		{"ok status wins", ptrace.SpanKindServer, ptrace.StatusCodeOk, map[string]any{"http.response.status_code": 500}, spanStatus{"ok", "5xx"}},
+		{"client request timeout", ptrace.SpanKindClient, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 408}, spanStatus{"timeout", "4xx"}},
+		{"server request timeout", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 408}, spanStatus{"ok", "4xx"}},
+		{"gateway timeout", ptrace.SpanKindClient, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 504}, spanStatus{"timeout", "5xx"}},
+		{"timeout error type", ptrace.SpanKindClient, ptrace.StatusCodeError, map[string]any{"error.type": "java.net.SocketTimeoutException"}, spanStatus{outcome: "timeout"}},
+		{"deadline error type", ptrace.SpanKindClient, ptrace.StatusCodeError, map[string]any{"error.type": "context.DeadlineExceeded"}, spanStatus{outcome: "timeout"}},
+		{"invalid status code", ptrace.SpanKindServer, ptrace.StatusCodeUnset, map[string]any{"http.response.status_code": 999}, spanStatus{outcome: "ok"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			span := ptrace.NewSpan()
+			span.SetKind(tc.kind)
+			span.Status().SetCode(tc.statusCode)
+			if err := span.Attributes().FromRaw(tc.attrs); err != nil {
+				t.Fatalf("FromRaw returned error: %v", err)
+			}
+			got := spanStatusOf(span, spanKindLabel(tc.kind), defaultStatusCodeAttribute)
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestConnectorEmitsRequestCountsAndErrorRatio(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	sink := &metricsSink{}
+	conn := newTestConnector(t, cfg, sink)
+
+	td := ptrace.NewTraces()
+	for _, statusCode := range []int64{200, 200, 201, 503} {
+		addServerSpan(td, "integration-a", "/v1/orders", "GET", 0, 100*time.Millisecond)
+		lastSpan(td).Attributes().PutInt(cfg.StatusCodeAttribute, statusCode)
+	}
+	if err := conn.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("ConsumeTraces returned error: %v", err)
+	}
+	if err := conn.flush(context.Background(), time.Unix(0, 0)); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	requests := metricByName(t, sink.batches[0], cfg.RequestsMetricName).Sum()
+	if requests.AggregationTemporality() != pmetric.AggregationTemporalityDelta || !requests.IsMonotonic() {
+		t.Fatalf("expected monotonic delta sum of requests")
+	}
+	counts := map[string]int64{}
+	for i := 0; i < requests.DataPoints().Len(); i++ {
+		dp := requests.DataPoints().At(i)
+		outcome, _ := dp.Attributes().Get(outcomeAttribute)
+		statusClass, _ := dp.Attributes().Get(statusClassAttribute)
+		counts[outcome.AsString()+"/"+statusClass.AsString()] = dp.IntValue()
+	}
+	if len(counts) != 2 || counts["ok/2xx"] != 3 || counts["error/5xx"] != 1 {
+		t.Fatalf("expected 3 ok/2xx and 1 error/5xx requests, got %v", counts)
+	}
+
+	errorRatio := metricByName(t, sink.batches[0], cfg.ErrorRatioMetricName).Gauge().DataPoints()
+	if errorRatio.Len() != 1 {
+		t.Fatalf("expected error ratio of single series, got %d data points", errorRatio.Len())
+	}
+	if ratio := errorRatio.At(0).DoubleValue(); ratio != 0.25 {
+		t.Fatalf("expected error ratio 0.25, got %v", ratio)
+	}
+	if id, _ := errorRatio.At(0).Attributes().Get(defaultIntegrationIDAttribute); id.AsString() != "integration-a" {
+		t.Fatalf("expected error ratio of integration-a, got %s", id.AsString())
+	}
+}
+
+func newTestConnector(t *testing.T, cfg *Config, next consumer.Metrics) *latenciesConnector {
+	t.Helper()
+	conn, err := newLatenciesConnector(newConnectorSettings(), cfg, next)
+	if err != nil {
+		t.Fatalf("newLatenciesConnector returned error: %v", err)
+	}
+	return conn
+}
+
 func newConnectorSettings() connector.Settings {
 	return connector.Settings{
 		ID:                component.MustNewID("latencies"),
@@ -307,26 +1560,55 @@ func appendSpan(td ptrace.Traces) ptrace.Span {
 	return td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
 }
 
+func lastSpan(td ptrace.Traces) ptrace.Span {
+	rs := td.ResourceSpans().At(td.ResourceSpans().Len() - 1)
+	ss := rs.ScopeSpans().At(rs.ScopeSpans().Len() - 1)
+	return ss.Spans().At(ss.Spans().Len() - 1)
+}
+
 func metricNameOf(md pmetric.Metrics) string {
 	return md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Name()
 }
 
-func allDataPoints(md pmetric.Metrics) []pmetric.NumberDataPoint {
+// latencyDataPoints collects data points of latency percentile gauges.
+func latencyDataPoints(md pmetric.Metrics) []pmetric.NumberDataPoint {
 	var dps []pmetric.NumberDataPoint
+	for _, m := range allMetrics(md) {
+		if m.Type() != pmetric.MetricTypeGauge || m.Unit() != metricUnit {
+			continue
+		}
+		g := m.Gauge().DataPoints()
+		for l := 0; l < g.Len(); l++ {
+			dps = append(dps, g.At(l))
+		}
+	}
+	return dps
+}
+
+func allMetrics(md pmetric.Metrics) []pmetric.Metric {
+	var metrics []pmetric.Metric
 	rms := md.ResourceMetrics()
 	for i := 0; i < rms.Len(); i++ {
 		sms := rms.At(i).ScopeMetrics()
 		for j := 0; j < sms.Len(); j++ {
 			ms := sms.At(j).Metrics()
 			for k := 0; k < ms.Len(); k++ {
-				g := ms.At(k).Gauge().DataPoints()
-				for l := 0; l < g.Len(); l++ {
-					dps = append(dps, g.At(l))
-				}
+				metrics = append(metrics, ms.At(k))
 			}
 		}
 	}
-	return dps
+	return metrics
+}
+
+func metricByName(t *testing.T, md pmetric.Metrics, name string) pmetric.Metric {
+	t.Helper()
+	for _, m := range allMetrics(md) {
+		if m.Name() == name {
+			return m
+		}
+	}
+	t.Fatalf("expected metric %s", name)
+	return pmetric.Metric{}
 }
 
 type metricsSink struct {
@@ -341,3 +1623,32 @@ func (s *metricsSink) ConsumeMetrics(_ context.Context, md pmetric.Metrics) erro
 	s.batches = append(s.batches, md)
 	return nil
 }
+
+// channelSink hands emitted batches to test waiting for asynchronous flush.
+type channelSink struct {
+	received chan pmetric.Metrics
+}
+
+func newChannelSink() *channelSink {
+	return &channelSink{received: make(chan pmetric.Metrics, 16)}
+}
+
+func (s *channelSink) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{}
+}
+
+func (s *channelSink) ConsumeMetrics(_ context.Context, md pmetric.Metrics) error {
+	s.received <- md
+	return nil
+}
+
+func (s *channelSink) next(t *testing.T) pmetric.Metrics {
+	t.Helper()
+	select {
+	case md := <-s.received:
+		return md
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected metrics batch")
+		return pmetric.Metrics{}
+	}
+}
@@ -0,0 +1,76 @@
+package latencies
+
+import (
+	"sync"
+)
+
+// seriesLimiter admits at most maxSeries series in total and
+// maxPerIntegration series of every integration, spans of series over
+// limits are accumulated in the single overflow series. Series are admitted
+// under shard locks when batch is committed and delta flush resets the
+// limiter under the same locks as series are drained, so every interval
+// keeps within limits.
+type seriesLimiter struct {
+	maxSeries         int
+	maxPerIntegration int
+
+	mu             sync.RWMutex
+	admitted       map[seriesKey]struct{}
+	perIntegration map[string]int
+}
+
+// overflowKey is the key of series collecting spans over limits.
+var overflowKey = seriesKey{overflow: true}
+
+func newSeriesLimiter(maxSeries int, maxPerIntegration int) *seriesLimiter {
+	if maxSeries <= 0 && maxPerIntegration <= 0 {
+		return nil
+	}
+	return &seriesLimiter{
+		maxSeries:         maxSeries,
+		maxPerIntegration: maxPerIntegration,
+		admitted:          make(map[seriesKey]struct{}),
+		perIntegration:    make(map[string]int),
+	}
+}
+
+// admit returns key of admitted series or overflow key, nil limiter admits
+// all series.
+func (l *seriesLimiter) admit(key seriesKey) (seriesKey, bool) {
+	if l == nil {
+		return key, true
+	}
+
+	l.mu.RLock()
+	_, found := l.admitted[key]
+	l.mu.RUnlock()
+	if found {
+		return key, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, found := l.admitted[key]; found {
+		return key, true
+	}
+	if l.maxSeries > 0 && len(l.admitted) >= l.maxSeries {
+		return overflowKey, false
+	}
+	if l.maxPerIntegration > 0 && l.perIntegration[key.integrationID] >= l.maxPerIntegration {
+		return overflowKey, false
+	}
+	l.admitted[key] = struct{}{}
+	l.perIntegration[key.integrationID]++
+	return key, true
+}
+
+// reset forgets admitted series after they were drained from the store.
+func (l *seriesLimiter) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.admitted)
+	clear(l.perIntegration)
+}
@@ -0,0 +1,165 @@
+package latencies
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// IntegrationOverride overrides configuration of integrations, unset fields
+// keep the connector-wide configuration.
+type IntegrationOverride struct {
+	// Percentiles is the set of quantiles computed for the integration.
+	Percentiles []float64 `mapstructure:"percentiles"`
+	// DigestCapacity is the number of centroids kept by latency digests,
+	// higher capacity gives more precise extreme percentiles at cost of
+	// memory of every series. Defaults to 100, at most 5000.
+	DigestCapacity int `mapstructure:"digest_capacity"`
+	// SpanKinds is the set of span kinds measured for the integration.
+	SpanKinds []string `mapstructure:"span_kinds"`
+}
+
+// maxDigestCapacity bounds memory of digests preallocating centroids for
+// twice their capacity.
+const maxDigestCapacity = 5000
+
+// maxResolvedIntegrations bounds cache of settings resolved by patterns,
+// settings of integrations over it are matched on every span.
+const maxResolvedIntegrations = 10000
+
+func (o IntegrationOverride) validate(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid integration pattern %q: %w", pattern, err)
+	}
+	if err := validatePercentiles(o.Percentiles); err != nil {
+		return fmt.Errorf("integration %q: %w", pattern, err)
+	}
+	if o.DigestCapacity < 0 || o.DigestCapacity > maxDigestCapacity {
+		return fmt.Errorf("integration %q: digest_capacity must be in [0, %d], got %d", pattern, maxDigestCapacity, o.DigestCapacity)
+	}
+	for _, kind := range o.SpanKinds {
+		if !isKnownSpanKind(kind) {
+			return fmt.Errorf("integration %q: unknown span kind %q, valid values are %v", pattern, kind, allSpanKinds())
+		}
+	}
+	return nil
+}
+
+// integrationSettings are effective settings of integration series.
+type integrationSettings struct {
+	percentiles    []float64
+	digestCapacity int
+	enabledKinds   map[string]bool
+}
+
+type patternSettings struct {
+	pattern  string
+	settings *integrationSettings
+}
+
+// integrationOverrides resolves settings of integrations. Exact integration
+// id wins over glob patterns, longer patterns win over shorter ones.
+type integrationOverrides struct {
+	defaults *integrationSettings
+	exact    map[string]*integrationSettings
+	patterns []patternSettings
+	// anyKind lists kinds enabled by any settings, so spans of disabled
+	// kinds are skipped before resolving their integration.
+	anyKind map[string]bool
+	// resolved caches settings matched by patterns per integration id,
+	// overrides never change after start.
+	resolved      sync.Map
+	resolvedCount atomic.Int64
+}
+
+func newIntegrationOverrides(cfg *Config) *integrationOverrides {
+	o := &integrationOverrides{
+		defaults: &integrationSettings{
+			percentiles:    cfg.Percentiles,
+			digestCapacity: tdigestCapacity,
+			enabledKinds:   make(map[string]bool, len(cfg.SpanKinds)),
+		},
+		exact:   make(map[string]*integrationSettings),
+		anyKind: make(map[string]bool),
+	}
+	for _, kind := range cfg.SpanKinds {
+		o.defaults.enabledKinds[kind] = true
+		o.anyKind[kind] = true
+	}
+
+	for pattern, override := range cfg.Integrations {
+		settings := o.override(override)
+		if strings.ContainsAny(pattern, `*?[\`) {
+			o.patterns = append(o.patterns, patternSettings{pattern: pattern, settings: settings})
+		} else {
+			o.exact[pattern] = settings
+		}
+	}
+	slices.SortFunc(o.patterns, func(a, b patternSettings) int {
+		if byLength := cmp.Compare(len(b.pattern), len(a.pattern)); byLength != 0 {
+			return byLength
+		}
+		return strings.Compare(a.pattern, b.pattern)
+	})
+	return o
+}
+
+func (o *integrationOverrides) override(override IntegrationOverride) *integrationSettings {
+	settings := *o.defaults
+	if len(override.Percentiles) > 0 {
+		settings.percentiles = override.Percentiles
+	}
+	if override.DigestCapacity > 0 {
+		settings.digestCapacity = override.DigestCapacity
+	}
+	if len(override.SpanKinds) > 0 {
+		settings.enabledKinds = make(map[string]bool, len(override.SpanKinds))
+		for _, kind := range override.SpanKinds {
+			settings.enabledKinds[kind] = true
+			o.anyKind[kind] = true
+		}
+	}
+	return &settings
+}
+
+// seriesSettings resolves settings of series, overflow series mixes spans of
+// all integrations and always uses defaults.
+func (o *integrationOverrides) seriesSettings(key seriesKey) *integrationSettings {
+	if key.overflow {
+		return o.defaults
+	}
+	return o.settingsOf(key.integrationID)
+}
+
+func (o *integrationOverrides) settingsOf(integrationID string) *integrationSettings {
+	if settings, found := o.exact[integrationID]; found {
+		return settings
+	}
+	if len(o.patterns) == 0 {
+		return o.defaults
+	}
+	if settings, found := o.resolved.Load(integrationID); found {
+		return settings.(*integrationSettings)
+	}
+	settings := o.matchPattern(integrationID)
+	if o.resolvedCount.Load() < maxResolvedIntegrations {
+		if _, loaded := o.resolved.LoadOrStore(integrationID, settings); !loaded {
+			o.resolvedCount.Add(1)
+		}
+	}
+	return settings
+}
+
+func (o *integrationOverrides) matchPattern(integrationID string) *integrationSettings {
+	for _, candidate := range o.patterns {
+		// patterns were validated, malformed one never matches
+		if matched, _ := path.Match(candidate.pattern, integrationID); matched {
+			return candidate.settings
+		}
+	}
+	return o.defaults
+}
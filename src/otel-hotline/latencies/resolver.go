@@ -0,0 +1,113 @@
+package latencies
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// IntegrationRule resolves integration id of span from a span or resource
+// attribute, e.g. server.address, peer.service or url.full.
+type IntegrationRule struct {
+	// Attribute is the attribute key, looked up in span attributes first
+	// and in resource attributes second.
+	Attribute string `mapstructure:"attribute"`
+	// ExtractHost matches host of URL values instead of the whole URL.
+	// Always enabled for URL attributes http.url, url.full and
+	// server.address, other values such as integration ids are matched
+	// unchanged.
+	ExtractHost bool `mapstructure:"extract_host"`
+	// Host is a glob pattern of attribute value, e.g. "*.stripe.com",
+	// matched case-insensitively. Empty pattern matches every value.
+	Host string `mapstructure:"host"`
+	// IntegrationID is the integration id of matching spans. Defaults to
+	// the matched value, it is required with Host.
+	IntegrationID string `mapstructure:"integration_id"`
+}
+
+func (r IntegrationRule) validate() error {
+	if r.Attribute == "" {
+		return fmt.Errorf("integration rule attribute must not be empty")
+	}
+	if r.Host == "" {
+		return nil
+	}
+	if _, err := path.Match(r.Host, ""); err != nil {
+		return fmt.Errorf("invalid integration rule host pattern %q: %w", r.Host, err)
+	}
+	if r.IntegrationID == "" {
+		return fmt.Errorf("integration rule with host %q must have integration_id", r.Host)
+	}
+	return nil
+}
+
+// urlAttributes carry URL or host values, so rules of them always extract
+// host.
+var urlAttributes = map[string]bool{
+	"http.url":       true,
+	"url.full":       true,
+	"server.address": true,
+}
+
+// integrationResolver evaluates integration rules in order, the first
+// matching rule wins.
+type integrationResolver struct {
+	rules []IntegrationRule
+}
+
+// newIntegrationResolver falls back to a single rule taking integration id
+// from the integration id attribute when no rules are configured.
+func newIntegrationResolver(cfg *Config) *integrationResolver {
+	rules := cfg.IntegrationIDRules
+	if len(rules) == 0 {
+		rules = []IntegrationRule{{Attribute: cfg.IntegrationIDAttribute}}
+	}
+	resolved := make([]IntegrationRule, len(rules))
+	for i, rule := range rules {
+		rule.Host = strings.ToLower(rule.Host)
+		rule.ExtractHost = rule.ExtractHost || urlAttributes[rule.Attribute]
+		resolved[i] = rule
+	}
+	return &integrationResolver{rules: resolved}
+}
+
+func (r *integrationResolver) resolve(spanAttrs pcommon.Map, resourceAttrs pcommon.Map) (string, bool) {
+	for _, rule := range r.rules {
+		value, found := stringAttr(spanAttrs, rule.Attribute)
+		if !found {
+			value, found = stringAttr(resourceAttrs, rule.Attribute)
+		}
+		if !found {
+			continue
+		}
+		if rule.ExtractHost {
+			value = hostOf(value)
+		}
+		if rule.Host == "" {
+			if rule.IntegrationID != "" {
+				return rule.IntegrationID, true
+			}
+			return value, true
+		}
+		// pattern was validated, malformed one never matches
+		if matched, _ := path.Match(rule.Host, strings.ToLower(value)); matched {
+			return rule.IntegrationID, true
+		}
+	}
+	return "", false
+}
+
+// hostOf returns host of absolute URL, other values are returned unchanged.
+func hostOf(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return value
+	}
+	return parsed.Hostname()
+}
@@ -0,0 +1,132 @@
+package latencies
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// RouteNormalization derives route of spans missing the route attribute from
+// their URL, so client spans calling raw URLs are grouped by route.
+type RouteNormalization struct {
+	// URLAttributes are span attribute keys of URL path or full URL, the
+	// first present one is normalized. Defaults to url.path and url.full.
+	URLAttributes []string `mapstructure:"url_attributes"`
+	// Rules normalize URL paths, the first matching rule wins.
+	Rules []RouteRule `mapstructure:"rules"`
+	// DetectIDs replaces UUID, numeric and hash path segments with {id}
+	// when no rule matches. Defaults to true.
+	DetectIDs bool `mapstructure:"detect_ids"`
+}
+
+// RouteRule maps URL paths matching pattern to route template.
+type RouteRule struct {
+	// Pattern is a regular expression matched against URL path.
+	Pattern string `mapstructure:"pattern"`
+	// Template is the route of matching paths, $1 or ${name} expand to
+	// submatches of pattern, e.g. "/v1/customers/{id}/$1".
+	Template string `mapstructure:"template"`
+}
+
+const idPlaceholder = "{id}"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// minHashLength is the shortest hexadecimal segment considered a hash or
+// hex encoded id, shorter ones are mostly words.
+const minHashLength = 16
+
+type routeNormalizer struct {
+	urlAttributes []string
+	rules         []compiledRouteRule
+	detectIDs     bool
+}
+
+type compiledRouteRule struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+func newRouteNormalizer(cfg RouteNormalization) (*routeNormalizer, error) {
+	n := &routeNormalizer{
+		urlAttributes: cfg.URLAttributes,
+		detectIDs:     cfg.DetectIDs,
+	}
+	for _, rule := range cfg.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route rule pattern %q: %w", rule.Pattern, err)
+		}
+		n.rules = append(n.rules, compiledRouteRule{pattern: pattern, template: rule.Template})
+	}
+	return n, nil
+}
+
+// route normalizes path of the first present URL attribute.
+func (n *routeNormalizer) route(attrs pcommon.Map) (string, bool) {
+	for _, key := range n.urlAttributes {
+		value, ok := stringAttr(attrs, key)
+		if !ok {
+			continue
+		}
+		path, ok := urlPath(value)
+		if !ok {
+			continue
+		}
+		return n.normalize(path), true
+	}
+	return "", false
+}
+
+func (n *routeNormalizer) normalize(path string) string {
+	for _, rule := range n.rules {
+		match := rule.pattern.FindStringSubmatchIndex(path)
+		if match != nil {
+			return string(rule.pattern.ExpandString(nil, rule.template, path, match))
+		}
+	}
+	if !n.detectIDs {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isID(segment) {
+			segments[i] = idPlaceholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// urlPath returns path of full URL or of path with query, "/" for URL
+// without path.
+func urlPath(value string) (string, bool) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	if parsed.Path == "" {
+		return "/", true
+	}
+	return parsed.Path, true
+}
+
+// isID detects UUID, numeric and hexadecimal hash segments.
+func isID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if uuidPattern.MatchString(segment) {
+		return true
+	}
+	digits, hex := true, true
+	for _, r := range segment {
+		isDigit := r >= '0' && r <= '9'
+		isHex := isDigit || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+		digits = digits && isDigit
+		hex = hex && isHex
+	}
+	return digits || (hex && len(segment) >= minHashLength)
+}
@@ -0,0 +1,68 @@
+package latencies
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	scopeName = "github.com/petercipov/hotline/otel-hotline/latencies"
+
+	dropReasonMissingAttribute      = "missing_attribute"
+	dropReasonNegativeDuration      = "negative_duration"
+	dropReasonUnresolvedIntegration = "unresolved_integration"
+)
+
+// telemetry reports spans the connector dropped or accumulated in the
+// overflow series.
+type telemetry struct {
+	spansDropped    metric.Int64Counter
+	spansOverflowed metric.Int64Counter
+}
+
+func newTelemetry(settings component.TelemetrySettings) (*telemetry, error) {
+	meter := settings.MeterProvider.Meter(scopeName)
+	spansDropped, err := meter.Int64Counter(
+		"otelcol_connector_latencies_spans_dropped",
+		metric.WithDescription("Number of spans not measured by the latencies connector."),
+		metric.WithUnit("{span}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	spansOverflowed, err := meter.Int64Counter(
+		"otelcol_connector_latencies_spans_overflowed",
+		metric.WithDescription("Number of spans accumulated in the overflow series over series limits."),
+		metric.WithUnit("{span}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &telemetry{spansDropped: spansDropped, spansOverflowed: spansOverflowed}, nil
+}
+
+// spanCounts counts dropped and overflowed spans of a single traces batch,
+// so counters are updated once per batch.
+type spanCounts struct {
+	dropped    map[string]int64
+	overflowed int64
+}
+
+func (s *spanCounts) drop(reason string) {
+	if s.dropped == nil {
+		s.dropped = make(map[string]int64)
+	}
+	s.dropped[reason]++
+}
+
+func (t *telemetry) record(ctx context.Context, counts spanCounts) {
+	for reason, count := range counts.dropped {
+		t.spansDropped.Add(ctx, count, metric.WithAttributes(attribute.String("reason", reason)))
+	}
+	if counts.overflowed > 0 {
+		t.spansOverflowed.Add(ctx, counts.overflowed)
+	}
+}
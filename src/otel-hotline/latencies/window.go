@@ -0,0 +1,105 @@
+package latencies
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"hotline/metrics"
+)
+
+// WindowConfig configures sliding window of emitted metrics. Windows of
+// consecutive emissions overlap, so percentiles and request counts are
+// emitted as gauges and only gauge output is supported.
+type WindowConfig struct {
+	// Size is the duration covered by every emission, e.g. 5m to emit p99
+	// over the last 5 minutes. Zero disables sliding window and every
+	// emission covers single interval.
+	Size time.Duration `mapstructure:"size"`
+	// Step is how often window slides and metrics are emitted, replacing
+	// interval. Windows end at multiples of step, regardless of when
+	// emission ticks. Size must be a multiple of step. Defaults to
+	// interval.
+	Step time.Duration `mapstructure:"step"`
+}
+
+func (w WindowConfig) enabled() bool {
+	return w.Size > 0
+}
+
+// step falls back to interval of tumbling window.
+func (w WindowConfig) step(interval time.Duration) time.Duration {
+	if w.Step > 0 {
+		return w.Step
+	}
+	return interval
+}
+
+func (c *Config) validateWindow() error {
+	if c.Window.Size < 0 || c.Window.Step < 0 {
+		return fmt.Errorf("window size and step must not be negative, got %s and %s", c.Window.Size, c.Window.Step)
+	}
+	if !c.Window.enabled() {
+		return nil
+	}
+	step := c.Window.step(c.Interval)
+	if step > c.Window.Size {
+		return fmt.Errorf("window step %s must not exceed window size %s", step, c.Window.Size)
+	}
+	if c.Window.Size%step != 0 {
+		return fmt.Errorf("window size %s must be a multiple of window step %s", c.Window.Size, step)
+	}
+	if c.AggregationTemporality != temporalityDelta {
+		return fmt.Errorf("window requires %q aggregation_temporality", temporalityDelta)
+	}
+	for _, output := range c.Outputs {
+		if output != outputGauge {
+			return fmt.Errorf("window supports only %q output, %q would count spans of overlapping windows repeatedly", outputGauge, output)
+		}
+	}
+	return nil
+}
+
+// seriesWindow keeps series accumulated per step of sliding window.
+type seriesWindow = metrics.SlidingWindow[spanSample, *latencySeries]
+type windowStore = metrics.ShardedStore[seriesKey, spanSample, *seriesWindow]
+
+// newSeriesWindow creates series of steps lazily, with smaller digest
+// buffers than series of tumbling window.
+func (c *latenciesConnector) newSeriesWindow(key seriesKey) *seriesWindow {
+	return metrics.NewSlidingWindow[spanSample](
+		func() *latencySeries { return c.newBufferedSeries(key, stepBufferSize) },
+		c.cfg.Window.Size,
+		c.cfg.Window.step(c.cfg.Interval),
+		metrics.WithClock(c.clock),
+	)
+}
+
+// flushWindows emits metrics of the latest completed window of every series
+// as a single metrics batch. Data points start at start of the window.
+// Series without values in any kept window are evicted, series limits are
+// rebuilt from every series kept, including ones with values only in the
+// step in progress.
+func (c *latenciesConnector) flushWindows(ctx context.Context, now time.Time) error {
+	end := now.Truncate(c.cfg.Window.step(c.cfg.Interval))
+	builder := c.newMetricsBuilder(end)
+	c.limiter.reset()
+	c.windows.DeleteFunc(func(key seriesKey, window *seriesWindow) bool {
+		completed := window.GetCompletedWindow(now)
+		if completed == nil && !window.HasValues(now) {
+			return true
+		}
+		if !key.overflow {
+			c.limiter.admit(key)
+		}
+		if completed != nil {
+			completed.Accumulator.startTime = completed.StartTime
+			builder.addSeries(key, completed.Accumulator)
+		}
+		return false
+	})
+	if builder.isEmpty() {
+		return nil
+	}
+	return c.next.ConsumeMetrics(ctx, builder.md)
+}